@@ -0,0 +1,333 @@
+package pichiwmap
+
+import (
+	"math"
+	"time"
+
+	"github.com/gowasm/gopherwasm/js"
+)
+
+// Easing maps the fraction of an animation's time that has passed, from 0 to
+// 1, to the fraction of the way it has moved
+type Easing func(t float64) float64
+
+// Easings for EaseTo
+var (
+	// EaseLinear moves at a constant speed
+	EaseLinear Easing = func(t float64) float64 { return t }
+	// EaseOut starts fast and slows to a stop
+	EaseOut Easing = func(t float64) float64 { return 1 - (1-t)*(1-t)*(1-t) }
+	// EaseInOut speeds up then slows down
+	EaseInOut Easing = func(t float64) float64 {
+		if t < 0.5 {
+			return 4 * t * t * t
+		}
+		return 1 - math.Pow(-2*t+2, 3)/2
+	}
+)
+
+// flyCurve is van Wijk and Nuij's rho, how far FlyTo zooms out. Bigger flies
+// higher.
+const flyCurve = 1.42
+
+// flySpeed is how fast FlyTo goes when it's given no duration, in screenfuls a
+// second along its path
+const flySpeed = 1.2
+
+// position is a point an animation passes through
+type position struct {
+	zoom, lat, lon, bearing float64
+}
+
+// animation moves the map along a path over a duration. start is the
+// timestamp of its first frame, 0 until then.
+type animation struct {
+	start    float64
+	duration float64
+	easing   Easing
+	at       func(k float64) position
+}
+
+// EaseTo animates the map to zoom, lat and lon over duration. The center moves
+// in a straight line across the map while the zoom changes. Any user input or
+// new position stops it.
+func (m *Map) EaseTo(zoom, lat, lon float64, duration time.Duration, easing Easing) {
+	m.mu.Lock()
+	defer m.unlock()
+
+	m.easeTo(zoom, lat, lon, m.bearing, duration, easing)
+}
+
+// easeTo is EaseTo turning to bearing on the way, the short way round
+func (m *Map) easeTo(zoom, lat, lon, bearing float64, duration time.Duration, easing Easing) {
+	zoom = math.Max(m.minZoom, math.Min(m.maxZoom, zoom))
+	x0, y0, x1, y1 := m.projectPath(lat, lon)
+	z0, b0 := m.zoom, m.bearing
+	turn := NormalizeLon(bearing - b0)
+
+	m.animate(duration, easing, func(k float64) position {
+		lat, lon := m.proj.Unproject(x0+(x1-x0)*k, y0+(y1-y0)*k)
+		return position{zoom: z0 + (zoom-z0)*k, lat: lat, lon: lon, bearing: b0 + turn*k}
+	})
+}
+
+// FlyTo animates the map to zoom, lat and lon, zooming out in the middle so
+// far away places are reached quickly without the map blurring past. It
+// follows van Wijk and Nuij's "Smooth and efficient zooming and panning". If
+// duration is 0 it's worked out from the length of the flight.
+func (m *Map) FlyTo(zoom, lat, lon float64, duration time.Duration) {
+	m.mu.Lock()
+	defer m.unlock()
+
+	zoom = math.Max(m.minZoom, math.Min(m.maxZoom, zoom))
+	x0, y0, x1, y1 := m.projectPath(lat, lon)
+
+	// Widths of the view in projected units at either end, and the distance
+	// between them
+	size := math.Max(1, math.Max(float64(m.width), float64(m.height)))
+	w0 := size * ZoomResolution(m.proj, m.zoom)
+	w1 := size * ZoomResolution(m.proj, zoom)
+	u1 := math.Hypot(x1-x0, y1-y0)
+
+	s, u, w := flyPath(w0, w1, u1, flyCurve)
+
+	if duration <= 0 {
+		duration = time.Duration(s / flySpeed * float64(time.Second))
+	}
+
+	z0, b0 := m.zoom, m.bearing
+	m.animate(duration, EaseInOut, func(k float64) position {
+		if k >= 1 {
+			return position{zoom: zoom, lat: lat, lon: lon, bearing: b0}
+		}
+		f := 0.0
+		if u1 > 0 {
+			f = u(k*s) / u1
+		}
+		plat, plon := m.proj.Unproject(x0+(x1-x0)*f, y0+(y1-y0)*f)
+		pzoom := z0 + ResolutionZoom(m.proj, w(k*s)/size) - ResolutionZoom(m.proj, w0/size)
+		return position{zoom: pzoom, lat: plat, lon: plon, bearing: b0}
+	})
+}
+
+// flyPath works out van Wijk and Nuij's optimal path from a view w0 wide to
+// one w1 wide, u1 away. It returns the length of the path and functions giving
+// the distance travelled and width of the view s along it.
+func flyPath(w0, w1, u1, rho float64) (s float64, u, w func(s float64) float64) {
+	rho2 := rho * rho
+
+	if u1 < 1e-9*math.Max(w0, w1) {
+		// Only zooming, go straight there
+		if w0 == w1 {
+			return 0, func(float64) float64 { return 0 }, func(float64) float64 { return w0 }
+		}
+		k := 1.0
+		if w1 < w0 {
+			k = -1
+		}
+		s = math.Abs(math.Log(w1/w0)) / rho
+		return s,
+			func(float64) float64 { return 0 },
+			func(s float64) float64 { return w0 * math.Exp(k*rho*s) }
+	}
+
+	r := func(i int) float64 {
+		wi, sign := w0, 1.0
+		if i == 1 {
+			wi, sign = w1, -1
+		}
+		b := (w1*w1 - w0*w0 + sign*rho2*rho2*u1*u1) / (2 * wi * rho2 * u1)
+		return math.Log(math.Sqrt(b*b+1) - b)
+	}
+	r0, r1 := r(0), r(1)
+	s = (r1 - r0) / rho
+
+	u = func(s float64) float64 {
+		return w0 / rho2 * (math.Cosh(r0)*math.Tanh(rho*s+r0) - math.Sinh(r0))
+	}
+	w = func(s float64) float64 {
+		return w0 * math.Cosh(r0) / math.Cosh(rho*s+r0)
+	}
+	return s, u, w
+}
+
+// animateZoomAround animates zooming to zoom while the point at x and y on the
+// canvas stays where it is
+func (m *Map) animateZoomAround(zoom, x, y float64, duration time.Duration) {
+	gx, gy := m.groundAnchor(x, y)
+	alat, alon := MoveBy(m.proj, m.zoom, m.lat, m.lon, gx, gy)
+	z0, b0 := m.zoom, m.bearing
+
+	m.animate(duration, EaseOut, func(k float64) position {
+		z := z0 + (zoom-z0)*k
+		lat, lon := AnchorCenter(m.proj, z, alat, alon, gx, gy)
+		return position{zoom: z, lat: lat, lon: lon, bearing: b0}
+	})
+}
+
+// projectPath returns the center and the target in projected units, with the
+// target's longitude moved the short way round on maps that wrap
+func (m *Map) projectPath(lat, lon float64) (x0, y0, x1, y1 float64) {
+	if WrapsX(m.proj) {
+		lon = m.lon + NormalizeLon(lon-m.lon)
+	}
+	x0, y0 = m.proj.Project(m.lat, m.lon)
+	x1, y1 = m.proj.Project(lat, lon)
+	return
+}
+
+// animate starts moving the map along at, which gives the position k of the
+// way along. Any animation already running is stopped.
+func (m *Map) animate(duration time.Duration, easing Easing, at func(k float64) position) {
+	// Start the new gesture before stopping the old one so no end events are
+	// fired in between
+	m.startGesture()
+	m.stop()
+
+	if m.closed {
+		m.endGesture()
+		return
+	}
+	if easing == nil {
+		easing = EaseLinear
+	}
+
+	if duration <= 0 {
+		m.moveTo(at(1))
+		m.endGesture()
+		return
+	}
+
+	m.animation = &animation{
+		duration: float64(duration) / float64(time.Millisecond),
+		easing:   easing,
+		at:       at,
+	}
+	m.frameID = js.Global().Call("requestAnimationFrame", m.frame)
+}
+
+// moveTo sets the position to p, keeping the zoom in range
+func (m *Map) moveTo(p position) {
+	zoom := math.Max(m.minZoom, math.Min(m.maxZoom, p.zoom))
+	m.setBearing(p.bearing)
+	m.setPosition(zoom, p.lat, p.lon, 1)
+}
+
+// stop stops the running animation, if there is one, where it is
+func (m *Map) stop() {
+	if m.animation == nil {
+		return
+	}
+	m.animation = nil
+	js.Global().Call("cancelAnimationFrame", m.frameID)
+	m.endGesture()
+}
+
+// onFrame moves the running animation on to timestamp, in milliseconds
+func (m *Map) onFrame(timestamp float64) {
+	a := m.animation
+	if a == nil {
+		return
+	}
+	if a.start == 0 {
+		a.start = timestamp
+	}
+
+	k := math.Min(1, (timestamp-a.start)/a.duration)
+	if k >= 1 {
+		m.moveTo(a.at(1))
+	} else {
+		m.moveTo(a.at(a.easing(k)))
+	}
+
+	if k >= 1 {
+		m.animation = nil
+		m.endGesture()
+		return
+	}
+	m.frameID = js.Global().Call("requestAnimationFrame", m.frame)
+}
+
+// now returns the time in milliseconds, on the same clock as event and
+// animation frame timestamps
+func now() float64 {
+	return js.Global().Get("performance").Call("now").Float()
+}
+
+// dragSample is where the pointer was at time t during a drag
+type dragSample struct {
+	t, x, y float64
+}
+
+// dragSampleAge is how far back, in milliseconds, drag samples are kept to
+// work out the speed of the drag. If the pointer stays still for longer than
+// this before it's released the map doesn't glide.
+const dragSampleAge = 100
+
+// glideMinSpeed is the speed, in CSS pixels a second, a glide stops at
+const glideMinSpeed = 10
+
+// sampleDrag records the pointer at x and y at time t, dropping old samples
+func (m *Map) sampleDrag(t, x, y float64) {
+	m.dragSamples = append(m.dragSamples, dragSample{t: t, x: x, y: y})
+
+	i := 0
+	for i < len(m.dragSamples)-1 && t-m.dragSamples[i].t > dragSampleAge {
+		i++
+	}
+	m.dragSamples = m.dragSamples[i:]
+}
+
+// dragVelocity returns how fast the pointer was moving at time t in CSS
+// pixels a second, from the samples younger than dragSampleAge
+func (m *Map) dragVelocity(t float64) (vx, vy float64) {
+	var recent []dragSample
+	for _, s := range m.dragSamples {
+		if t-s.t <= dragSampleAge {
+			recent = append(recent, s)
+		}
+	}
+	if len(recent) < 2 {
+		return 0, 0
+	}
+
+	first, last := recent[0], recent[len(recent)-1]
+	dt := (last.t - first.t) / 1000
+	if dt <= 0 {
+		return 0, 0
+	}
+	return (last.x - first.x) / dt, (last.y - first.y) / dt
+}
+
+// glide carries on a drag released at time t, slowing the map exponentially
+// from the speed the pointer was moving
+func (m *Map) glide(t float64) {
+	// The pointer moved across the canvas, which is turned from the ground
+	vx, vy := m.dragVelocity(t)
+	vx, vy = rotate(vx, vy, m.bearing)
+	m.dragSamples = nil
+
+	speed := math.Hypot(vx, vy)
+	if speed < glideMinSpeed {
+		return
+	}
+	if speed > m.inertiaMaxSpeed {
+		vx *= m.inertiaMaxSpeed / speed
+		vy *= m.inertiaMaxSpeed / speed
+		speed = m.inertiaMaxSpeed
+	}
+
+	// The speed falls as e^(-decel t) so the map travels speed/decel in all,
+	// stopping once it's too slow to see
+	decel := m.inertiaDeceleration
+	seconds := math.Log(speed/glideMinSpeed) / decel
+	zoom, lat, lon, bearing := m.zoom, m.lat, m.lon, m.bearing
+
+	m.animate(time.Duration(seconds*float64(time.Second)), EaseLinear, func(k float64) position {
+		travelled := (1 - math.Exp(-decel*seconds*k)) / decel
+
+		// The map moves the opposite way to the pointer
+		glat, glon := MoveBy(m.proj, zoom, lat, lon, -vx*travelled, -vy*travelled)
+		return position{zoom: zoom, lat: glat, lon: glon, bearing: bearing}
+	})
+}
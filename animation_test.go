@@ -0,0 +1,82 @@
+package pichiwmap
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEasings(t *testing.T) {
+	easings := map[string]Easing{
+		"EaseLinear": EaseLinear,
+		"EaseOut":    EaseOut,
+		"EaseInOut":  EaseInOut,
+	}
+
+	for name, easing := range easings {
+		if got := easing(0); got != 0 {
+			t.Errorf("%v(0) = %v, want 0", name, got)
+		}
+		if got := easing(1); got != 1 {
+			t.Errorf("%v(1) = %v, want 1", name, got)
+		}
+
+		// Never goes backwards
+		prev := 0.0
+		for i := 1; i <= 100; i++ {
+			k := easing(float64(i) / 100)
+			if k < prev {
+				t.Errorf("%v(%v) = %v, less than %v before it", name, float64(i)/100, k, prev)
+			}
+			prev = k
+		}
+	}
+
+	if got := EaseInOut(0.5); got != 0.5 {
+		t.Errorf("EaseInOut(0.5) = %v, want 0.5", got)
+	}
+}
+
+func TestFlyPath(t *testing.T) {
+	tests := []struct {
+		name       string
+		w0, w1, u1 float64
+	}{
+		{"pan", 1000, 1000, 5000},
+		{"zoom in and pan", 4000, 1000, 5000},
+		{"zoom out and pan", 1000, 4000, 5000},
+		{"far", 1000, 1000, 1e7},
+		{"zoom in", 4000, 1000, 0},
+		{"zoom out", 1000, 4000, 0},
+		{"still", 1000, 1000, 0},
+	}
+
+	for _, tt := range tests {
+		s, u, w := flyPath(tt.w0, tt.w1, tt.u1, flyCurve)
+		if s < 0 || math.IsNaN(s) {
+			t.Errorf("%v: length %v", tt.name, s)
+			continue
+		}
+
+		near := func(got, want float64) bool {
+			return math.Abs(got-want) <= 1e-9*math.Max(1, math.Abs(want))
+		}
+		if got := u(0); !near(got, 0) {
+			t.Errorf("%v: u(0) = %v, want 0", tt.name, got)
+		}
+		if got := u(s); !near(got, tt.u1) {
+			t.Errorf("%v: u(%v) = %v, want %v", tt.name, s, got, tt.u1)
+		}
+		if got := w(0); !near(got, tt.w0) {
+			t.Errorf("%v: w(0) = %v, want %v", tt.name, got, tt.w0)
+		}
+		if got := w(s); !near(got, tt.w1) {
+			t.Errorf("%v: w(%v) = %v, want %v", tt.name, s, got, tt.w1)
+		}
+	}
+
+	// Far flights zoom out on the way
+	s, _, w := flyPath(1000, 1000, 1e7, flyCurve)
+	if mid := w(s / 2); mid <= 1000 {
+		t.Errorf("far: w(%v) = %v, want wider than 1000", s/2, mid)
+	}
+}
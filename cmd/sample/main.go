@@ -29,26 +29,25 @@ func main() {
 		panic(err)
 	}
 
-	events := pichiwmap.MapEvents{
-		OnLatChanged: func(lat float64) {
-			latEl.Set("value", strconv.FormatFloat(lat, 'f', 6, 64))
-		},
-		OnLonChanged: func(lon float64) {
-			lonEl.Set("value", strconv.FormatFloat(lon, 'f', 6, 64))
-		},
-		OnZoomChanged: func(zoom float64) {
-			zoomEl.Set("value", strconv.FormatFloat(zoom, 'f', 6, 64))
-		},
-	}
+	options := pichiwmap.DefaultOptions()
+	options.Lat = 49.8951
+	options.Lon = -97.1384
+	options.Zoom = 15
+	options.BackgroundColor = "#ddd"
 
-	m, err := pichiwmap.New(pichiwmap.NewOpenStreetMapURLer(baseURL), divEl, events)
+	m, err := pichiwmap.New(pichiwmap.NewOpenStreetMapURLer(baseURL), divEl, options)
 	if err != nil {
 		panic(err)
 	}
 
-	events.OnLatChanged(m.Lat())
-	events.OnLonChanged(m.Lon())
-	events.OnZoomChanged(m.Zoom())
+	showPosition := func(e pichiwmap.Event) {
+		latEl.Set("value", strconv.FormatFloat(e.Lat, 'f', 6, 64))
+		lonEl.Set("value", strconv.FormatFloat(e.Lon, 'f', 6, 64))
+		zoomEl.Set("value", strconv.FormatFloat(e.Zoom, 'f', 6, 64))
+	}
+	m.On(pichiwmap.EventMove, showPosition)
+	m.On(pichiwmap.EventZoom, showPosition)
+	showPosition(pichiwmap.Event{Lat: m.Lat(), Lon: m.Lon(), Zoom: m.Zoom()})
 
 	tr, err := pmwgl.NewTileRenderer(m.Canvas())
 	if err != nil {
@@ -86,6 +85,6 @@ func onUpdateClick(m *pichiwmap.Map, zoomEl, latEl, lonEl js.Value) func(event j
 			return
 		}
 
-		m.SetPosition(zoom, lat, lon)
+		m.FlyTo(zoom, lat, lon, 0)
 	}
 }
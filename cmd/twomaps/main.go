@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+
+	"syscall/js"
+
+	"github.com/pichiw/pichiwmap"
+	"github.com/pichiw/pichiwmap/pmwgl"
+)
+
+func main() {
+	doc := js.Global().Get("document")
+
+	baseURL, err := url.Parse("https://a.tile.openstreetmap.org")
+	if err != nil {
+		panic(err)
+	}
+	urlEr := pichiwmap.NewOpenStreetMapURLer(baseURL)
+
+	newMap(doc, urlEr, "left", 49.8951, -97.1384, 12)
+	newMap(doc, urlEr, "right", 51.5074, -0.1278, 10)
+
+	c := make(chan struct{}, 0)
+	<-c
+}
+
+// newMap creates a map in the div with the given id, showing its position in
+// the div's status element
+func newMap(doc js.Value, urlEr pichiwmap.URLer, id string, lat, lon, zoom float64) *pichiwmap.Map {
+	divEl := doc.Call("getElementById", id)
+	statusEl := doc.Call("getElementById", id+"-status")
+
+	options := pichiwmap.DefaultOptions()
+	options.Lat = lat
+	options.Lon = lon
+	options.Zoom = zoom
+	options.BackgroundColor = "#ddd"
+
+	m, err := pichiwmap.New(urlEr, divEl, options)
+	if err != nil {
+		panic(err)
+	}
+
+	tr, err := pmwgl.NewTileRenderer(m.Canvas())
+	if err != nil {
+		panic(err)
+	}
+	m.AddTileRenderers(tr)
+
+	showPosition := func(e pichiwmap.Event) {
+		statusEl.Set("innerText", fmt.Sprintf("%v: %.5f, %.5f z%.2f", id, e.Lat, e.Lon, e.Zoom))
+	}
+	m.On(pichiwmap.EventMove, showPosition)
+	m.On(pichiwmap.EventZoom, showPosition)
+	showPosition(pichiwmap.Event{Lat: m.Lat(), Lon: m.Lon(), Zoom: m.Zoom()})
+
+	m.Update(pichiwmap.ZoomingZero)
+	return m
+}
@@ -0,0 +1,172 @@
+package pichiwmap
+
+import "sync"
+
+// EventType identifies a kind of map event
+type EventType string
+
+// Map events
+const (
+	// EventClick is fired when the map is clicked without being dragged
+	EventClick EventType = "click"
+	// EventDoubleClick is fired when the map is double clicked
+	EventDoubleClick EventType = "dblclick"
+	// EventContextMenu is fired when the map is right clicked
+	EventContextMenu EventType = "contextmenu"
+	// EventMoveStart is fired when the center starts changing
+	EventMoveStart EventType = "movestart"
+	// EventMove is fired every time the center changes
+	EventMove EventType = "move"
+	// EventMoveEnd is fired when the center stops changing
+	EventMoveEnd EventType = "moveend"
+	// EventZoomStart is fired when the zoom starts changing
+	EventZoomStart EventType = "zoomstart"
+	// EventZoom is fired every time the zoom changes
+	EventZoom EventType = "zoom"
+	// EventZoomEnd is fired when the zoom stops changing
+	EventZoomEnd EventType = "zoomend"
+	// EventRotateStart is fired when the bearing starts changing
+	EventRotateStart EventType = "rotatestart"
+	// EventRotate is fired every time the bearing changes
+	EventRotate EventType = "rotate"
+	// EventRotateEnd is fired when the bearing stops changing
+	EventRotateEnd EventType = "rotateend"
+	// EventIdle is fired once the map has stopped moving and all of its tiles
+	// have loaded
+	EventIdle EventType = "idle"
+)
+
+// Event is passed to event handlers
+type Event struct {
+	Type EventType
+	// Lat and Lon are under the pointer for pointer events, and the center of
+	// the map otherwise. They are NaN for pointers above the horizon.
+	Lat float64
+	Lon float64
+	// Zoom is the zoom of the map
+	Zoom float64
+	// Bearing is the bearing of the map
+	Bearing float64
+	// X and Y are the pointer position in CSS pixels from the top left of the
+	// canvas, for pointer events
+	X float64
+	Y float64
+}
+
+// Handler handles map events
+type Handler func(e Event)
+
+type subscription struct {
+	id      int
+	handler Handler
+}
+
+// eventBus fans events out to any number of handlers per event type
+type eventBus struct {
+	m        sync.Mutex
+	nextID   int
+	handlers map[EventType][]subscription
+}
+
+// on subscribes h to events of type t, returning a function that unsubscribes it
+func (b *eventBus) on(t EventType, h Handler) (unsubscribe func()) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if b.handlers == nil {
+		b.handlers = map[EventType][]subscription{}
+	}
+
+	b.nextID++
+	id := b.nextID
+	b.handlers[t] = append(b.handlers[t], subscription{id: id, handler: h})
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { b.off(t, id) })
+	}
+}
+
+func (b *eventBus) off(t EventType, id int) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	subs := b.handlers[t]
+	for i, s := range subs {
+		if s.id == id {
+			// Copy so a fire in progress keeps its own slice
+			b.handlers[t] = append(subs[:i:i], subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// fire calls the handlers of e.Type in the order they subscribed. Handlers may
+// subscribe and unsubscribe while being called.
+func (b *eventBus) fire(e Event) {
+	b.m.Lock()
+	subs := b.handlers[e.Type]
+	b.m.Unlock()
+
+	for _, s := range subs {
+		s.handler(e)
+	}
+}
+
+type (
+	// OnLatChanged is called when the latitude changes.
+	//
+	// Deprecated: use Map.On with EventMove.
+	OnLatChanged func(lat float64)
+	// OnLonChanged is called when the longitude changes.
+	//
+	// Deprecated: use Map.On with EventMove.
+	OnLonChanged func(lon float64)
+	// OnZoomChanged is called when the zoom changes.
+	//
+	// Deprecated: use Map.On with EventZoom.
+	OnZoomChanged func(zoom float64)
+)
+
+// MapEvents are the callbacks New used to take. Any of them can be nil.
+//
+// Deprecated: use Map.On.
+type MapEvents struct {
+	OnLatChanged  OnLatChanged
+	OnLonChanged  OnLonChanged
+	OnZoomChanged OnZoomChanged
+}
+
+// OnMapEvents subscribes the callbacks in e, calling OnLatChanged and
+// OnLonChanged only when that coordinate changes. Calling the returned function
+// unsubscribes them.
+//
+// Deprecated: use On with EventMove and EventZoom.
+func (m *Map) OnMapEvents(e MapEvents) (unsubscribe func()) {
+	var mu sync.Mutex
+	lat, lon := m.Lat(), m.Lon()
+
+	offMove := m.On(EventMove, func(ev Event) {
+		mu.Lock()
+		latChanged, lonChanged := ev.Lat != lat, ev.Lon != lon
+		lat, lon = ev.Lat, ev.Lon
+		mu.Unlock()
+
+		if latChanged && e.OnLatChanged != nil {
+			e.OnLatChanged(ev.Lat)
+		}
+		if lonChanged && e.OnLonChanged != nil {
+			e.OnLonChanged(ev.Lon)
+		}
+	})
+	offZoom := m.On(EventZoom, func(ev Event) {
+		if e.OnZoomChanged != nil {
+			e.OnZoomChanged(ev.Zoom)
+		}
+	})
+
+	return func() {
+		offMove()
+		offZoom()
+	}
+}
@@ -0,0 +1,95 @@
+package pichiwmap
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEventBus(t *testing.T) {
+	var b eventBus
+	var got []string
+
+	offA := b.on(EventMove, func(e Event) { got = append(got, "a") })
+	b.on(EventMove, func(e Event) { got = append(got, "b") })
+	b.on(EventZoom, func(e Event) { got = append(got, "zoom") })
+
+	b.fire(Event{Type: EventMove})
+	if want := []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("fired %v, want %v", got, want)
+	}
+
+	got = nil
+	offA()
+	offA()
+	b.fire(Event{Type: EventMove})
+	b.fire(Event{Type: EventIdle})
+	if want := []string{"b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("fired %v after unsubscribing, want %v", got, want)
+	}
+}
+
+func TestEventBusUnsubscribeWhileFiring(t *testing.T) {
+	var b eventBus
+	calls := 0
+
+	// The first handler unsubscribes the second, which still gets the event
+	// being fired but not the next one
+	var offSecond func()
+	b.on(EventZoom, func(e Event) { offSecond() })
+	offSecond = b.on(EventZoom, func(e Event) { calls++ })
+
+	b.fire(Event{Type: EventZoom})
+	b.fire(Event{Type: EventZoom})
+	if calls != 1 {
+		t.Errorf("unsubscribed handler called %v times, want 1", calls)
+	}
+}
+
+func TestEventsFireAfterUnlock(t *testing.T) {
+	m, _ := newTestMap()
+
+	done := make(chan Event, 1)
+	m.On(EventMove, func(e Event) {
+		// Calling back into the map would deadlock if it were still locked
+		if m.Lat() != e.Lat {
+			t.Errorf("handler saw lat %v, event has %v", m.Lat(), e.Lat)
+		}
+		done <- e
+	})
+
+	go m.SetPosition(4, 10, 20)
+
+	select {
+	case e := <-done:
+		if e.Lat != 10 || e.Lon != 20 || e.Zoom != 4 {
+			t.Errorf("move event at %v, %v zoom %v, want 10, 20 zoom 4", e.Lat, e.Lon, e.Zoom)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("move event not fired, the map may still be locked")
+	}
+}
+
+func TestOnMapEvents(t *testing.T) {
+	m, _ := newTestMap()
+
+	var lats, lons, zooms []float64
+	off := m.OnMapEvents(MapEvents{
+		OnLatChanged:  func(lat float64) { lats = append(lats, lat) },
+		OnLonChanged:  func(lon float64) { lons = append(lons, lon) },
+		OnZoomChanged: func(zoom float64) { zooms = append(zooms, zoom) },
+	})
+
+	m.SetPosition(2, 10, 0)
+	m.SetPosition(3, 10, 20)
+	off()
+	m.SetPosition(4, 30, 40)
+
+	if !reflect.DeepEqual(lats, []float64{10}) || !reflect.DeepEqual(lons, []float64{20}) || !reflect.DeepEqual(zooms, []float64{3}) {
+		t.Errorf("lats %v lons %v zooms %v, want [10] [20] [3]", lats, lons, zooms)
+	}
+
+	// Nil callbacks are skipped
+	m.OnMapEvents(MapEvents{})
+	m.SetPosition(5, 0, 0)
+}
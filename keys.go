@@ -0,0 +1,170 @@
+package pichiwmap
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/gowasm/gopherwasm/js"
+)
+
+// KeyAction is what pressing a key does to the map
+type KeyAction int
+
+// Key actions
+const (
+	// KeyNone does nothing, binding a key to it disables the key
+	KeyNone KeyAction = iota
+	// KeyPanUp, KeyPanDown, KeyPanLeft and KeyPanRight pan the map by
+	// Options.KeyboardPanPixels
+	KeyPanUp
+	KeyPanDown
+	KeyPanLeft
+	KeyPanRight
+	// KeyPageUp and KeyPageDown pan the map by most of its height
+	KeyPageUp
+	KeyPageDown
+	// KeyZoomIn and KeyZoomOut zoom the map by Options.KeyboardZoomStep
+	KeyZoomIn
+	KeyZoomOut
+	// KeyHome moves the map back to where it started
+	KeyHome
+)
+
+// KeyBindings maps keys, as KeyboardEvent.key values such as "ArrowUp" or "w",
+// to what they do. Letters are matched case insensitively.
+type KeyBindings map[string]KeyAction
+
+// DefaultKeyBindings returns the arrow keys, WASD, +/-, Page Up/Down and Home
+func DefaultKeyBindings() KeyBindings {
+	return KeyBindings{
+		"ArrowUp":    KeyPanUp,
+		"ArrowDown":  KeyPanDown,
+		"ArrowLeft":  KeyPanLeft,
+		"ArrowRight": KeyPanRight,
+		"w":          KeyPanUp,
+		"s":          KeyPanDown,
+		"a":          KeyPanLeft,
+		"d":          KeyPanRight,
+		"+":          KeyZoomIn,
+		"=":          KeyZoomIn,
+		"-":          KeyZoomOut,
+		"_":          KeyZoomOut,
+		"PageUp":     KeyPageUp,
+		"PageDown":   KeyPageDown,
+		"Home":       KeyHome,
+	}
+}
+
+// Action returns what key does, matching letters case insensitively
+func (b KeyBindings) Action(key string) KeyAction {
+	if a, ok := b[key]; ok {
+		return a
+	}
+	return b[keyName(key)]
+}
+
+// keyName lower cases single character keys so "W" with shift or caps lock
+// held is bound the same as "w"
+func keyName(key string) string {
+	if len([]rune(key)) == 1 {
+		return strings.ToLower(key)
+	}
+	return key
+}
+
+// keyboardPanDuration is how long each key press takes to move the map
+const keyboardPanDuration = 250 * time.Millisecond
+
+// keyboardPageFraction is how much of the map's height Page Up and Page Down
+// pan by
+const keyboardPageFraction = 0.75
+
+// keyFilter is the body of a JavaScript function of the bound keys that
+// returns a keydown listener. It prevents the default action, such as
+// scrolling the page, of the keys onKeyDown uses.
+const keyFilter = `return function(event) {
+	var key = event.key;
+	if (key && key.length === 1) {
+		key = key.toLowerCase();
+	}
+	if (event.target === event.currentTarget && !event.ctrlKey && !event.metaKey && !event.altKey && keys[key]) {
+		event.preventDefault();
+	}
+};`
+
+// SetKeyBinding changes what key does. Binding it to KeyNone disables it.
+func (m *Map) SetKeyBinding(key string, action KeyAction) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.boundKeys.Set(keyName(key), action != KeyNone)
+	if action == KeyNone {
+		delete(m.keyBindings, keyName(key))
+		return
+	}
+	m.keyBindings[keyName(key)] = action
+}
+
+func (m *Map) onKeyDown(event js.Value) {
+	if !m.keyboard {
+		return
+	}
+
+	// Leave keys typed into inputs inside the container alone, and shortcuts
+	// to the browser
+	if event.Get("target") != m.container || held(event, "ctrlKey") || held(event, "metaKey") || held(event, "altKey") {
+		return
+	}
+
+	action := m.keyBindings.Action(event.Get("key").String())
+	if action == KeyNone {
+		return
+	}
+
+	// keyFilter has already prevented the default action of the keys the map
+	// uses, so tab and typing still work. Held keys add to the target so the
+	// map keeps going, otherwise start from where the map is.
+	if !m.keyDown || m.animation == nil {
+		m.tzoom = m.zoom
+		m.tlat = m.lat
+		m.tlon = m.lon
+	}
+	m.keyDown = true
+
+	var dx, dy float64
+	switch action {
+	case KeyPanUp:
+		dy = -m.keyboardPanPixels
+	case KeyPanDown:
+		dy = m.keyboardPanPixels
+	case KeyPanLeft:
+		dx = -m.keyboardPanPixels
+	case KeyPanRight:
+		dx = m.keyboardPanPixels
+	case KeyPageUp:
+		dy = -float64(m.height) * keyboardPageFraction
+	case KeyPageDown:
+		dy = float64(m.height) * keyboardPageFraction
+	case KeyZoomIn:
+		m.tzoom += m.keyboardZoomStep
+	case KeyZoomOut:
+		m.tzoom -= m.keyboardZoomStep
+	case KeyHome:
+		m.tzoom, m.tlat, m.tlon = m.homeZoom, m.homeLat, m.homeLon
+	}
+
+	// Up is the top of the canvas, whichever way the map is turned
+	dx, dy = rotate(dx, dy, m.bearing)
+
+	m.tzoom = math.Max(m.minZoom, math.Min(m.maxZoom, m.tzoom))
+	m.tlat, m.tlon = MoveBy(m.proj, m.tzoom, m.tlat, m.tlon, dx, dy)
+	m.tzoom, m.tlat, m.tlon = m.constrain(m.tzoom, m.tlat, m.tlon, 1)
+	m.tlat, m.tlon = ClampLatLon(m.proj, m.tlat, m.tlon)
+
+	m.easeTo(m.tzoom, m.tlat, m.tlon, m.bearing, keyboardPanDuration, EaseOut)
+}
+
+func (m *Map) onKeyUp(event js.Value) {
+	m.keyDown = false
+}
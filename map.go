@@ -1,174 +1,558 @@
 package pichiwmap
 
 import (
+	"fmt"
 	"math"
+	"sync"
 	"time"
 
 	"github.com/gowasm/gopherwasm/js"
 )
 
-type (
-	// OnLatChanged is fired whent he latitude changes
-	OnLatChanged func(lat float64)
-	// OnLonChanged is fired when the longitude changes
-	OnLonChanged func(lon float64)
-	// OnZoomChanged is fired when the zoom changes
-	OnZoomChanged func(zoom float64)
-)
+// View is the position of the map handed to tile renderers
+type View struct {
+	Zoom       float64
+	Lat        float64
+	Lon        float64
+	Projection Projection
+	// PixelRatio is the number of canvas pixels per CSS pixel
+	PixelRatio float64
+	// Bearing is the compass direction at the top of the map, in degrees
+	// clockwise from north
+	Bearing float64
+}
 
-// MapEvents wraps all map events into a convenient type
-type MapEvents struct {
-	OnLatChanged  OnLatChanged
-	OnLonChanged  OnLonChanged
-	OnZoomChanged OnZoomChanged
+// TileRenderer is anything that can render tiles
+type TileRenderer interface {
+	RenderTiles(view View, tiles map[string]*Tile)
 }
 
-func (e MapEvents) wrapEmpty() MapEvents {
-	if e.OnLatChanged == nil {
-		e.OnLatChanged = func(float64) {}
-	}
-	if e.OnLonChanged == nil {
-		e.OnLonChanged = func(float64) {}
-	}
-	if e.OnZoomChanged == nil {
-		e.OnZoomChanged = func(float64) {}
-	}
-	return e
+// TileLoader is implemented by tile renderers that load tiles in the
+// background. They call the function given to OnLoaded whenever they finish
+// loading, so the map can tell when it is idle.
+type TileLoader interface {
+	Loading() bool
+	OnLoaded(func())
 }
 
-// TileRenderer is anything that can render tiles
-type TileRenderer interface {
-	RenderTiles(zoom, lat, lon float64, tiles map[string]*Tile)
+// ScreenProjector is implemented by tile renderers that don't draw the map flat
+// onto the canvas, such as when the camera is tilted. Ground coordinates are
+// pixels from the center of the map at the current zoom, screen coordinates
+// are CSS pixels from the top left of the canvas. view is where the map is,
+// which the renderer may not have been given yet.
+//
+// ScreenToGround returns false if no ground is drawn at the pixel, such as
+// above the horizon, along with the furthest drawn point in its direction.
+type ScreenProjector interface {
+	GroundToScreen(view View, gx, gy float64) (sx, sy float64)
+	ScreenToGround(view View, sx, sy float64) (gx, gy float64, ok bool)
 }
 
-// New creates a new map at the specified dib
-func New(urlEr URLer, divEl js.Value, events MapEvents) (*Map, error) {
+// New creates a new map at the specified div.
+//
+// With Keyboard on, the keys the map uses are kept from scrolling the page by a
+// listener made with the JavaScript Function constructor, as is the wheel with
+// WheelRequiresModifier on. A page with a Content Security Policy has to allow
+// 'unsafe-eval' in script-src for either.
+func New(urlEr URLer, divEl js.Value, options Options) (*Map, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
 	doc := js.Global().Get("document")
 
 	viewport := doc.Call("createElement", "canvas")
+	viewport.Get("style").Set("backgroundColor", options.BackgroundColor)
+
+	m := &Map{
+		lat:                 options.Lat,
+		lon:                 options.Lon,
+		zoom:                options.Zoom,
+		bearing:             NormalizeLon(options.Bearing),
+		zoomStep:            options.WheelZoomStep,
+		keyboardPanPixels:   options.KeyboardPanPixels,
+		keyboardZoomStep:    options.KeyboardZoomStep,
+		keyBindings:         KeyBindings{},
+		homeZoom:            options.Zoom,
+		homeLat:             options.Lat,
+		homeLon:             options.Lon,
+		pointers:            map[int]pointer{},
+		urlEr:               urlEr,
+		proj:                EPSG3857,
+		container:           divEl,
+		viewport:            viewport,
+		maxZoom:             options.MaxZoom,
+		minZoom:             options.MinZoom,
+		maxBounds:           options.MaxBounds,
+		maxBoundsViscosity:  options.MaxBoundsViscosity,
+		drag:                options.Drag,
+		wheelZoom:           options.Wheel,
+		keyboard:            options.Keyboard,
+		touch:               options.Touch,
+		inertia:             options.Inertia,
+		inertiaMaxSpeed:     options.InertiaMaxSpeed,
+		inertiaDeceleration: options.InertiaDeceleration,
+		wheelModifier:       options.WheelRequiresModifier,
+		wheelHint:           js.Undefined(),
+		doubleClickZoom:     options.DoubleClickZoom,
+		boxZoom:             options.BoxZoom,
+		box:                 js.Undefined(),
+		rotate:              options.Rotate,
+		northControl:        js.Undefined(),
+	}
 
-	onResize := func(event js.Value) {
-		width := divEl.Get("offsetWidth").Int()
-		height := divEl.Get("offsetHeight").Int()
-		viewport.Set("width", width)
-		viewport.Set("height", height)
+	// Copied so SetKeyBinding doesn't change the options
+	m.boundKeys = js.Global().Get("Object").New()
+	for key, action := range options.KeyBindings {
+		if action != KeyNone {
+			m.keyBindings[keyName(key)] = action
+			m.boundKeys.Set(keyName(key), true)
+		}
 	}
 
-	onResize(js.Null())
+	m.onResize(js.Null())
+
+	// The map starts inside the max bounds, which depends on the canvas size
+	m.zoom, m.lat, m.lon = m.constrain(m.zoom, m.lat, m.lon, 1)
+	m.lat, m.lon = ClampLatLon(m.proj, m.lat, m.lon)
+
+	m.frame = js.NewCallback(func(args []js.Value) {
+		m.mu.Lock()
+		defer m.unlock()
+		m.onFrame(args[0].Float())
+	})
 
 	divEl.Call("appendChild", viewport)
 
-	m := &Map{
-		events:   events.wrapEmpty(),
-		lat:      49.8951,
-		lon:      -97.1384,
-		zoom:     15,
-		zoomStep: 0.1,
-		step:     0.001,
-		urlEr:    urlEr,
-		viewport: viewport,
-		maxZoom:  18,
-		minZoom:  0,
+	window := js.Global().Get("window")
+	m.listen(window, "resize", 0, func(event js.Value) {
+		m.onResize(event)
+
+		// A bigger canvas can show ground past the max bounds
+		m.setPosition(m.zoom, m.lat, m.lon, 1)
+		m.update(ZoomingZero)
+	})
+
+	// Pointer events cover mice, touches and pens. The map handles touches
+	// itself rather than the browser panning and zooming the page.
+	m.viewport.Get("style").Set("touchAction", "none")
+	m.listen(m.viewport, "pointerdown", js.PreventDefault, m.onPointerDown)
+	m.listen(m.viewport, "pointermove", js.PreventDefault, m.onPointerMove)
+	m.listen(m.viewport, "pointerup", 0, m.onPointerUp)
+	m.listen(m.viewport, "pointercancel", 0, m.onPointerUp)
+
+	m.listen(m.viewport, "click", 0, m.onClick)
+	m.listen(m.viewport, "dblclick", 0, m.onDoubleClick)
+	// The right button turns the map, so the browser's menu would get in the
+	// way of it
+	var contextMenuFlags js.EventCallbackFlag
+	if options.Rotate {
+		contextMenuFlags = js.PreventDefault
+	}
+	m.listen(m.viewport, "contextmenu", contextMenuFlags, m.onContextMenu)
+
+	// Wheel events the map zooms with mustn't scroll the page. When
+	// WheelRequiresModifier is set the page still scrolls without ctrl or cmd,
+	// which has to be decided in JavaScript.
+	var wheelFlags js.EventCallbackFlag
+	if options.Wheel && !options.WheelRequiresModifier {
+		wheelFlags = js.PreventDefault
+	}
+	m.listen(m.viewport, "wheel", wheelFlags, m.wheel)
+	if options.Wheel && options.WheelRequiresModifier {
+		m.listenJS(m.viewport, "wheel", js.Global().Get("Function").New("event", wheelModifierFilter))
 	}
 
-	window := js.Global().Get("window")
-	window.Call("addEventListener", "resize", js.NewEventCallback(0, func(event js.Value) {
-		onResize(event)
-		m.Update(ZoomingZero)
-	}))
+	if options.WheelRequiresModifier {
+		m.wheelHint = newWheelHint(doc, options.WheelModifierHint)
 
-	m.tlat = m.Lat()
-	m.tlon = m.Lon()
+		m.positionContainer()
+		divEl.Call("appendChild", m.wheelHint)
+	}
 
-	m.viewport.Call("addEventListener", "mousedown", js.NewEventCallback(js.PreventDefault, m.onMouseDown), false)
-	m.viewport.Call("addEventListener", "mouseup", js.NewEventCallback(js.PreventDefault, m.onMouseUp), false)
-	m.viewport.Call("addEventListener", "mousemove", js.NewEventCallback(js.PreventDefault, m.onMouseMove), false)
+	// Keys go to whichever map has focus, so the container has to be focusable
+	if divEl.Call("getAttribute", "tabindex") == js.Null() {
+		divEl.Call("setAttribute", "tabindex", "0")
+	}
+	m.listen(divEl, "keyup", 0, m.onKeyUp)
+	m.listen(divEl, "keydown", 0, m.onKeyDown)
+	if options.Keyboard {
+		m.listenJS(divEl, "keydown", js.Global().Get("Function").New("keys", keyFilter).Invoke(m.boundKeys))
+	}
 
-	m.viewport.Call("addEventListener", "touchstart", js.NewEventCallback(js.PreventDefault, m.onTouchStart), false)
-	m.viewport.Call("addEventListener", "touchend", js.NewEventCallback(js.PreventDefault, m.onTouchEnd), false)
-	m.viewport.Call("addEventListener", "touchmove", js.NewEventCallback(js.PreventDefault, m.onTouchMove), false)
+	if options.ResetNorthControl {
+		m.northControl = newNorthControl(doc)
+		m.listen(m.northControl, "click", js.PreventDefault, func(event js.Value) {
+			m.rotateTo(0, resetNorthDuration)
+		})
 
-	doc.Call("addEventListener", "keyup", js.NewEventCallback(js.PreventDefault, m.onKeyUp), false)
-	doc.Call("addEventListener", "keydown", js.NewEventCallback(js.PreventDefault, m.onKeyDown), false)
-	doc.Call("addEventListener", "wheel", js.NewEventCallback(js.PreventDefault, m.wheel), false)
+		m.positionContainer()
+		divEl.Call("appendChild", m.northControl)
+		m.drawNorthControl()
+	}
 
 	return m, nil
 }
 
-// Map represents a map
+// listener is an event listener added by the map, kept so it can be removed.
+// release frees the Go callback behind fn, if there is one.
+type listener struct {
+	target  js.Value
+	typ     string
+	fn      js.Value
+	release func()
+}
+
+// listen adds an event listener that's removed when the map is closed. fn is
+// called with the map locked.
+func (m *Map) listen(target js.Value, typ string, flags js.EventCallbackFlag, fn func(event js.Value)) {
+	callback := js.NewEventCallback(flags, func(event js.Value) {
+		m.mu.Lock()
+		defer m.unlock()
+		fn(event)
+	})
+	target.Call("addEventListener", typ, callback.Value, false)
+	m.listeners = append(m.listeners, listener{target: target, typ: typ, fn: callback.Value, release: callback.Release})
+}
+
+// listenJS adds a JavaScript event listener that's removed when the map is
+// closed. Go callbacks run after the browser has handled the event, so
+// deciding whether to prevent its default action has to be done in
+// JavaScript.
+func (m *Map) listenJS(target js.Value, typ string, fn js.Value) {
+	target.Call("addEventListener", typ, fn, false)
+	m.listeners = append(m.listeners, listener{target: target, typ: typ, fn: fn})
+}
+
+// Close removes the map from the page. Its event listeners are removed and
+// their callbacks released, tile renderers with a Close method are closed and
+// the canvas is removed from the container. The map can't be used afterwards.
+func (m *Map) Close() {
+	m.mu.Lock()
+	defer m.unlock()
+
+	if m.closed {
+		return
+	}
+	m.stop()
+	m.frame.Release()
+	m.closed = true
+
+	for _, l := range m.listeners {
+		l.target.Call("removeEventListener", l.typ, l.fn, false)
+		if l.release != nil {
+			l.release()
+		}
+	}
+	m.listeners = nil
+
+	for _, r := range m.tileRenderers {
+		if c, ok := r.(interface{ Close() }); ok {
+			c.Close()
+		}
+	}
+	m.tileRenderers = nil
+
+	m.container.Call("removeChild", m.viewport)
+	if m.wheelHint != js.Undefined() {
+		m.container.Call("removeChild", m.wheelHint)
+	}
+	if m.box != js.Undefined() {
+		m.container.Call("removeChild", m.box)
+	}
+	if m.northControl != js.Undefined() {
+		m.container.Call("removeChild", m.northControl)
+	}
+}
+
+// Map represents a map. It's safe to use from multiple goroutines.
 type Map struct {
+	// mu guards everything below. Events are queued in pending while it's held
+	// and fired once it's unlocked, so handlers can call back into the map.
+	mu      sync.Mutex
+	pending []Event
+
 	tileRenderers []TileRenderer
+	listeners     []listener
+	closed        bool
+
+	// animation is the running EaseTo or FlyTo, moved on by frame
+	animation *animation
+	frame     js.Callback
+	frameID   js.Value
+
+	events eventBus
+
+	doc       js.Value
+	container js.Value
+	viewport  js.Value
+
+	// width and height are the size of the map in CSS pixels, the canvas is
+	// pixelRatio times bigger so it's sharp on high DPI displays
+	width      int
+	height     int
+	pixelRatio float64
+
+	urlEr              URLer
+	proj               Projection
+	zoom               float64
+	zoomStep           float64
+	lat                float64
+	lon                float64
+	bearing            float64
+	maxZoom            float64
+	minZoom            float64
+	maxBounds          *Bounds
+	gestures           int
+	moving             bool
+	zooming            bool
+	rotating           bool
+	idle               bool
+	maxBoundsViscosity float64
+	drag               bool
+	wheelZoom          bool
+	keyboard           bool
+	touch              bool
+
+	// wheelTarget is where the running wheelAnimation is zooming to
+	wheelTarget    float64
+	wheelAnimation *animation
+	wheelModifier  bool
+	wheelHint      js.Value
+	wheelHintShown int
+
+	// tzoom, tlat and tlon are where held keys are moving the map to.
+	// boundKeys is a JavaScript object of the keys in keyBindings, for
+	// keyFilter.
+	keyBindings       KeyBindings
+	boundKeys         js.Value
+	keyboardPanPixels float64
+	keyboardZoomStep  float64
+	keyDown           bool
+	tzoom             float64
+	tlat              float64
+	tlon              float64
+	homeZoom          float64
+	homeLat           float64
+	homeLon           float64
+
+	// pointers are the pointers that are down, by pointer ID. pointerType is
+	// the type of the last one pressed. pinchIDs are the IDs of the two
+	// pointers pinching, in the order they were pressed.
+	pointers    map[int]pointer
+	pointerType string
+	pinchIDs    [2]int
+
+	// dragged is set once a drag has moved far enough to not be a click
+	dragging     bool
+	dragged      bool
+	dragStartX   float64
+	dragStartY   float64
+	dragStartLat float64
+	dragStartLon float64
+
+	doubleClickZoom bool
+	lastTapTime     float64
+	lastTapX        float64
+	lastTapY        float64
+	// tapping is true while a single pointer hasn't moved far enough to drag
+	tapping      bool
+	tapStartTime float64
+	tapX         float64
+	tapY         float64
+
+	// pinchStartTime and pinchMoved tell two finger taps from pinches
+	pinching       bool
+	pinchZoomStart float64
+	pinchDistance  float64
+	pinchLat       float64
+	pinchLon       float64
+	pinchStartTime float64
+	pinchMoved     bool
+	pinchX         float64
+	pinchY         float64
+
+	// box is the rubber band drawn while shift dragging from boxX and boxY
+	boxZoom bool
+	boxDown bool
+	boxX    float64
+	boxY    float64
+	box     js.Value
+
+	// rotate turns rotating with two fingers and the right mouse button on,
+	// turning follows a mouse drag from turnStartX
+	rotate           bool
+	turning          bool
+	turnStartX       float64
+	turnStartBearing float64
+	northControl     js.Value
+
+	// twisting is set once two fingers have turned far enough to rotate the
+	// map, from pinchBearing when they were at pinchAngle
+	twisting     bool
+	pinchAngle   float64
+	pinchBearing float64
+
+	inertia             bool
+	inertiaMaxSpeed     float64
+	inertiaDeceleration float64
+	// dragSamples are the recent pointer positions of a drag, to work out how
+	// fast it was going when released
+	dragSamples []dragSample
+}
 
-	events MapEvents
+func (m *Map) onResize(event js.Value) {
+	m.width = m.container.Get("offsetWidth").Int()
+	m.height = m.container.Get("offsetHeight").Int()
 
-	doc      js.Value
-	viewport js.Value
+	m.pixelRatio = 1
+	if ratio := js.Global().Get("devicePixelRatio"); ratio != js.Undefined() {
+		m.pixelRatio = ratio.Float()
+	}
 
-	urlEr          URLer
-	zoom           float64
-	zoomStep       float64
-	lat            float64
-	lon            float64
-	tlat           float64
-	tlon           float64
-	step           float64
-	pinchZoomStart float64
-	pinchDelta     float64
-	pinchDown      bool
-	mouseStartX    int
-	mouseStartY    int
-	mouseStartLat  float64
-	mouseStartLon  float64
-	mouseDown      bool
-	arrowDown      bool
-	maxZoom        float64
-	minZoom        float64
+	m.viewport.Set("width", int(float64(m.width)*m.pixelRatio))
+	m.viewport.Set("height", int(float64(m.height)*m.pixelRatio))
+	m.viewport.Get("style").Set("width", fmt.Sprintf("%vpx", m.width))
+	m.viewport.Get("style").Set("height", fmt.Sprintf("%vpx", m.height))
+}
+
+// Size returns the width and height of the map in CSS pixels
+func (m *Map) Size() (width, height int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.width, m.height
 }
 
 // Zoom returns the current zoom
 func (m *Map) Zoom() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	return m.zoom
 }
 
-func (m *Map) setZoom(zoom float64) {
-	if m.zoom == zoom {
-		return
-	}
-	m.zoom = zoom
-	m.events.OnZoomChanged(zoom)
+// Bearing returns the current bearing, the compass direction at the top of
+// the map in degrees clockwise from north
+func (m *Map) Bearing() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.bearing
 }
 
 // Lat returns the current latitude
 func (m *Map) Lat() float64 {
-	return m.lat
-}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-func (m *Map) setLat(lat float64) {
-	if m.lat == lat {
-		return
-	}
-	m.lat = lat
-	m.events.OnLatChanged(lat)
+	return m.lat
 }
 
 // Lon returns the current longitude
 func (m *Map) Lon() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	return m.lon
 }
 
-func (m *Map) setLon(lon float64) {
-	if m.lon == lon {
+// On calls h whenever an event of type t happens. Any number of handlers can be
+// subscribed to each type. Calling the returned function unsubscribes h.
+func (m *Map) On(t EventType, h Handler) (unsubscribe func()) {
+	return m.events.on(t, h)
+}
+
+// unlock unlocks the map then fires the events queued while it was locked
+func (m *Map) unlock() {
+	pending := m.pending
+	m.pending = nil
+	m.mu.Unlock()
+
+	for _, e := range pending {
+		m.events.fire(e)
+	}
+}
+
+// fire queues an event at the center of the map
+func (m *Map) fire(t EventType) {
+	m.pending = append(m.pending, Event{Type: t, Lat: m.lat, Lon: m.lon, Zoom: m.zoom, Bearing: m.bearing})
+}
+
+// firePointer queues an event for the pointer at x and y on the canvas
+func (m *Map) firePointer(t EventType, x, y float64) {
+	lat, lon := m.unproject(x, y)
+	m.pending = append(m.pending, Event{Type: t, Lat: lat, Lon: lon, Zoom: m.zoom, Bearing: m.bearing, X: x, Y: y})
+}
+
+// startGesture marks the start of an interaction, such as a drag, that changes
+// the position many times. The start and end events bracket all the changes
+// until the matching endGesture.
+func (m *Map) startGesture() {
+	m.gestures++
+}
+
+func (m *Map) endGesture() {
+	if m.gestures > 0 {
+		m.gestures--
+	}
+	m.settle()
+}
+
+// settle fires the end events if nothing is moving the map any more, then
+// idle once the tiles have loaded too
+func (m *Map) settle() {
+	if m.gestures > 0 {
 		return
 	}
-	m.lon = lon
-	m.events.OnLonChanged(lon)
+	if m.moving {
+		m.moving = false
+		m.fire(EventMoveEnd)
+	}
+	if m.zooming {
+		m.zooming = false
+		m.fire(EventZoomEnd)
+	}
+	if m.rotating {
+		m.rotating = false
+		m.fire(EventRotateEnd)
+	}
+	m.checkIdle()
 }
 
-// SetPosition sets the current zoom, latitude, and longitude of the map
+// checkIdle fires EventIdle the first time the map is still with all its
+// tiles loaded after changing
+func (m *Map) checkIdle() {
+	if m.idle || m.gestures > 0 || m.moving || m.zooming || m.rotating {
+		return
+	}
+	for _, r := range m.tileRenderers {
+		if l, ok := r.(TileLoader); ok && l.Loading() {
+			return
+		}
+	}
+	m.idle = true
+	m.fire(EventIdle)
+}
+
+// SetPosition sets the current zoom, latitude, and longitude of the map. If the
+// map has max bounds the position is moved so the canvas stays inside them.
 func (m *Map) SetPosition(zoom, lat, lon float64) {
+	m.mu.Lock()
+	defer m.unlock()
+
+	m.stop()
+	m.setPosition(zoom, lat, lon, 1)
+}
+
+// setPosition sets the position, pulling it back inside the max bounds.
+// viscosity is how much of the way back it's pulled, between 0 and 1.
+func (m *Map) setPosition(zoom, lat, lon, viscosity float64) {
 	if zoom < m.minZoom || zoom > m.maxZoom {
 		return
 	}
+	zoom, lat, lon = m.constrain(zoom, lat, lon, viscosity)
+	lat, lon = ClampLatLon(m.proj, lat, lon)
 	if zoom == m.zoom && lat == m.lat && lon == m.lon {
 		return
 	}
@@ -178,191 +562,575 @@ func (m *Map) SetPosition(zoom, lat, lon float64) {
 	} else if zoom < m.zoom {
 		zooming = ZoomingOut
 	}
-	m.setZoom(zoom)
-	m.setLat(lat)
-	m.setLon(lon)
-	m.Update(zooming)
-}
 
-// AddTileRenderers adds tile renderers to the map
-func (m *Map) AddTileRenderers(tr ...TileRenderer) {
-	m.tileRenderers = append(m.tileRenderers, tr...)
+	moved := lat != m.lat || lon != m.lon
+	zoomed := zoom != m.zoom
+
+	m.zoom = zoom
+	m.lat = lat
+	m.lon = lon
+	m.idle = false
+
+	if zoomed {
+		if !m.zooming {
+			m.zooming = true
+			m.fire(EventZoomStart)
+		}
+		m.fire(EventZoom)
+	}
+	if moved {
+		if !m.moving {
+			m.moving = true
+			m.fire(EventMoveStart)
+		}
+		m.fire(EventMove)
+	}
+
+	m.update(zooming)
+
+	// Outside of a gesture every change starts and ends on its own
+	m.settle()
 }
 
-// Canvas returns the canvas the map is being drawn onto
-func (m *Map) Canvas() js.Value {
-	return m.viewport
+// SetBearing turns the map so bearing, in degrees clockwise from north, is at
+// the top
+func (m *Map) SetBearing(bearing float64) {
+	m.mu.Lock()
+	defer m.unlock()
+
+	m.stop()
+	m.setBearing(bearing)
 }
 
-func (m *Map) anim() {
-	if m.Lat() == m.tlat && m.Lon() == m.tlon {
+func (m *Map) setBearing(bearing float64) {
+	// Bearings wrap around like longitudes
+	bearing = NormalizeLon(bearing)
+	if bearing == m.bearing {
 		return
 	}
+	m.bearing = bearing
+	m.idle = false
 
-	var newLat float64
-	var newLon float64
+	if !m.rotating {
+		m.rotating = true
+		m.fire(EventRotateStart)
+	}
+	m.fire(EventRotate)
 
-	if m.tlat > m.Lat() {
-		newLat = m.Lat() + m.step
-		if newLat > m.tlat {
-			newLat = m.tlat
-		}
-	} else {
-		newLat = m.Lat() - m.step
-		if newLat < m.tlat {
-			newLat = m.tlat
-		}
+	m.drawNorthControl()
+	m.update(ZoomingZero)
+	m.settle()
+}
+
+// MaxBounds returns the bounds the map is kept inside, or nil if it isn't
+func (m *Map) MaxBounds() *Bounds {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.maxBounds
+}
+
+// SetMaxBounds keeps the map inside bounds, or lets it move freely if bounds
+// is nil. viscosity controls how hard dragging past the bounds is resisted,
+// from 0 where the map follows the pointer and springs back when released to 1
+// where it stops dead at the edge.
+func (m *Map) SetMaxBounds(bounds *Bounds, viscosity float64) {
+	m.mu.Lock()
+	defer m.unlock()
+
+	m.maxBounds = bounds
+	m.maxBoundsViscosity = math.Max(0, math.Min(1, viscosity))
+	m.setPosition(m.zoom, m.lat, m.lon, 1)
+}
+
+// boundsZoom returns the shallowest zoom at which the max bounds fill the canvas
+func (m *Map) boundsZoom() float64 {
+	minX, maxY := m.proj.Project(m.maxBounds.North, m.maxBounds.West)
+	maxX, minY := m.proj.Project(m.maxBounds.South, m.maxBounds.UnwrappedEast())
+
+	res := math.Min(
+		(maxX-minX)/math.Max(1, float64(m.width)),
+		(maxY-minY)/math.Max(1, float64(m.height)),
+	)
+
+	// fitZoom finds the deepest zoom coarser than res, step past it so the
+	// bounds are at least as big as the canvas
+	zoom := m.fitZoom(res, m.maxZoom)
+	if ZoomResolution(m.proj, zoom) > res {
+		zoom = math.Min(m.maxZoom, zoom+1e-9)
 	}
+	return zoom
+}
 
-	if m.tlon > m.Lon() {
-		newLon = m.Lon() + m.step
-		if newLon > m.tlon {
-			newLon = m.tlon
-		}
-	} else {
-		newLon = m.Lon() - m.step
-		if newLon < m.tlon {
-			newLon = m.tlon
-		}
+// constrain moves the position back towards the max bounds, viscosity of the
+// way, so none of the world outside them is on the canvas. The zoom is limited
+// to where the bounds fill the canvas. Like FitBounds the canvas is treated as
+// flat when tilted.
+func (m *Map) constrain(zoom, lat, lon, viscosity float64) (float64, float64, float64) {
+	b := m.maxBounds
+	if b == nil {
+		return zoom, lat, lon
+	}
+
+	zoom = math.Max(zoom, m.boundsZoom())
+
+	// Keep the center on the same side of the antimeridian as the bounds
+	if b.CrossesAntimeridian() && NormalizeLon(lon) < b.West {
+		lon = NormalizeLon(lon) + 360
 	}
 
-	m.SetPosition(m.zoom, newLat, newLon)
-	time.Sleep(100 * time.Millisecond)
-	go m.anim()
+	cx, cy := WorldXY(m.proj, zoom, lat, lon)
+	minX, minY := WorldXY(m.proj, zoom, b.North, b.West)
+	maxX, maxY := WorldXY(m.proj, zoom, b.South, b.UnwrappedEast())
+
+	x := clampCenter(cx, minX, maxX, float64(m.width)/2)
+	y := clampCenter(cy, minY, maxY, float64(m.height)/2)
+
+	x = cx + (x-cx)*viscosity
+	y = cy + (y-cy)*viscosity
+
+	lat, lon = WorldLatLon(m.proj, zoom, x, y)
+	return zoom, lat, lon
 }
 
-func (m *Map) wheel(event js.Value) {
-	var delta float64
-	if event.Get("deltaY").Int() < 0 {
-		delta = m.zoomStep
-	} else {
-		delta = -m.zoomStep
+// clampCenter keeps a view half pixels either side of c between min and max,
+// centering it if it doesn't fit
+func clampCenter(c, min, max, half float64) float64 {
+	if max-min < 2*half {
+		return (min + max) / 2
 	}
+	return math.Max(min+half, math.Min(max-half, c))
+}
+
+// ZoomRange returns the minimum and maximum zoom of the map
+func (m *Map) ZoomRange() (min, max float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	m.SetPosition(m.zoom+delta, m.lat, m.lon)
+	return m.minZoom, m.maxZoom
 }
 
-func (m *Map) onKeyDown(event js.Value) {
-	if event == js.Undefined() {
-		event = js.Global().Get("window").Get("event")
+// SetZoomRange sets the minimum and maximum zoom of the map, moving the zoom
+// into the range if it's outside. The maximum can't be more than
+// MaxZoomLevel. ErrZoomRange is returned if min is more than max.
+func (m *Map) SetZoomRange(min, max float64) error {
+	m.mu.Lock()
+	defer m.unlock()
+
+	return m.setZoomRange(math.Max(0, min), math.Min(MaxZoomLevel, max))
+}
+
+func (m *Map) setZoomRange(min, max float64) error {
+	if min > max {
+		return ErrZoomRange
 	}
+	m.minZoom = min
+	m.maxZoom = max
 
-	if event == js.Undefined() {
-		return
+	// Everything else keeps the zoom it has, which has to be in range
+	m.setPosition(math.Max(min, math.Min(max, m.zoom)), m.lat, m.lon, 1)
+	return nil
+}
+
+// ApplyTileJSON sets the minimum zoom of the map from the TileJSON document and
+// moves to its center if it has one. The maximum zoom is left alone, past the
+// document's maxzoom its tiles are scaled up. The bounds and attribution are
+// left to the caller, pass LatLonBounds to SetMaxBounds to keep the map inside
+// the bounds. ErrZoomRange is returned if the document's minzoom is more than
+// the map's maximum zoom.
+func (m *Map) ApplyTileJSON(t *TileJSON) error {
+	m.mu.Lock()
+	defer m.unlock()
+
+	if err := m.setZoomRange(math.Max(0, float64(t.MinZoom)), m.maxZoom); err != nil {
+		return err
 	}
+	if zoom, lat, lon, ok := t.CenterPosition(); ok {
+		m.setPosition(math.Max(m.minZoom, math.Min(m.maxZoom, zoom)), lat, lon, 1)
+	}
+	return nil
+}
 
-	if !m.arrowDown {
-		m.tlat = m.lat
-		m.tlon = m.lon
+func (m *Map) screenProjector() ScreenProjector {
+	for _, r := range m.tileRenderers {
+		if sp, ok := r.(ScreenProjector); ok {
+			return sp
+		}
 	}
-	switch event.Get("keyCode").Int() {
-	case 38: // up
-		m.tlat += 0.005
-	case 40: // down
-		m.tlat -= 0.005
-	case 37: // left
-		m.tlon -= 0.005
-	case 39: // right
-		m.tlon += 0.005
-	default:
-		return
+	return nil
+}
+
+// Project returns where latitude and longitude are drawn on the canvas, in CSS
+// pixels from its top left corner. On maps that wrap around the antimeridian
+// the world copy closest to the center is used.
+func (m *Map) Project(lat, lon float64) (x, y float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cx, cy := WorldXY(m.proj, m.zoom, m.lat, m.lon)
+	wx, wy := WorldXY(m.proj, m.zoom, lat, lon)
+	gx, gy := wx-cx, wy-cy
+
+	if WrapsX(m.proj) {
+		west, _ := WorldXY(m.proj, m.zoom, 0, -180)
+		east, _ := WorldXY(m.proj, m.zoom, 0, 180)
+		worldWidth := east - west
+		gx -= math.Floor(gx/worldWidth+0.5) * worldWidth
+	}
+
+	if sp := m.screenProjector(); sp != nil {
+		return sp.GroundToScreen(m.view(), gx, gy)
 	}
+	x, y = rotate(gx, gy, -m.bearing)
+	return x + float64(m.width)/2, y + float64(m.height)/2
+}
+
+// Unproject returns the latitude and longitude drawn at x and y on the canvas,
+// in CSS pixels from its top left corner. Both are NaN if no ground is drawn
+// there, such as above the horizon of a tilted map.
+func (m *Map) Unproject(x, y float64) (lat, lon float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.unproject(x, y)
+}
 
-	m.arrowDown = true
+func (m *Map) unproject(x, y float64) (lat, lon float64) {
+	gx, gy, ok := m.ground(x, y)
+	if !ok {
+		return math.NaN(), math.NaN()
+	}
+	lat, lon = m.groundLatLon(gx, gy)
+	return ClampLatLon(m.proj, lat, lon)
+}
 
-	go m.anim()
+// groundLatLon returns the latitude and longitude of the ground gx and gy
+// pixels from the center without wrapping longitude, so points in the world
+// copies either side are east or west of the center
+func (m *Map) groundLatLon(gx, gy float64) (lat, lon float64) {
+	cx, cy := WorldXY(m.proj, m.zoom, m.lat, m.lon)
+	return WorldLatLon(m.proj, m.zoom, cx+gx, cy+gy)
 }
 
-func (m *Map) onKeyUp(event js.Value) {
-	m.arrowDown = false
+// ground returns the point on the ground drawn at x and y on the canvas, in
+// pixels from the center of the map. If there's no ground there it returns
+// false and the furthest point drawn in that direction.
+func (m *Map) ground(x, y float64) (gx, gy float64, ok bool) {
+	if sp := m.screenProjector(); sp != nil {
+		return sp.ScreenToGround(m.view(), x, y)
+	}
+	gx, gy = rotate(x-float64(m.width)/2, y-float64(m.height)/2, m.bearing)
+	return gx, gy, true
 }
 
-func pinchDelta(touches js.Value) float64 {
-	if touches.Length() <= 1 {
-		return 0
+// groundAnchor returns the point on the ground at x and y on the canvas to zoom
+// around, or the center of the map if there's no ground there
+func (m *Map) groundAnchor(x, y float64) (gx, gy float64) {
+	if gx, gy, ok := m.ground(x, y); ok {
+		return gx, gy
 	}
-	t1 := touches.Index(0)
-	t2 := touches.Index(1)
+	return 0, 0
+}
 
-	x1 := t1.Get("pageX").Float()
-	y1 := t1.Get("pageY").Float()
+// rotate turns x and y clockwise around the origin by degrees, on the canvas
+// where y is down. The ground is turned anticlockwise by the bearing to draw
+// it, so canvas offsets are turned clockwise by it to get back to the ground.
+func rotate(x, y, degrees float64) (rx, ry float64) {
+	s, c := math.Sincos(degrees * math.Pi / 180)
+	return x*c - y*s, x*s + y*c
+}
 
-	x2 := t2.Get("pageX").Float()
-	y2 := t2.Get("pageY").Float()
+// positionContainer makes the container positioned if it isn't, so elements
+// can be laid over the map
+func (m *Map) positionContainer() {
+	position := js.Global().Call("getComputedStyle", m.container).Get("position").String()
+	if position == "static" || position == "" {
+		m.container.Get("style").Set("position", "relative")
+	}
+}
 
-	lx := math.Abs(x1 - x2)
-	ly := math.Abs(y1 - y2)
+// held returns true if the modifier key, such as "shiftKey", is held during
+// event. Touches don't have modifier keys.
+func held(event js.Value, key string) bool {
+	return event.Get(key) == js.ValueOf(true)
+}
 
-	return math.Sqrt(math.Pow(lx, 2) + math.Pow(ly, 2))
+// canvasXY converts viewport coordinates, like an event's clientX and clientY,
+// to CSS pixels from the top left of the canvas
+func (m *Map) canvasXY(clientX, clientY float64) (x, y float64) {
+	rect := m.viewport.Call("getBoundingClientRect")
+	return clientX - rect.Get("left").Float(), clientY - rect.Get("top").Float()
 }
 
-func (m *Map) onTouchStart(event js.Value) {
-	touches := event.Get("touches")
-	if touches == js.Undefined() {
-		return
+// SetZoomAround zooms the map to zoom keeping the point at x and y on the
+// canvas, in CSS pixels from its top left, where it is
+func (m *Map) SetZoomAround(zoom, x, y float64) {
+	m.mu.Lock()
+	defer m.unlock()
+
+	m.stop()
+	m.zoomAround(zoom, x, y)
+}
+
+func (m *Map) zoomAround(zoom, x, y float64) {
+	zoom = math.Max(m.minZoom, math.Min(m.maxZoom, zoom))
+	gx, gy := m.groundAnchor(x, y)
+	lat, lon := ZoomAround(m.proj, m.zoom, m.lat, m.lon, zoom, gx, gy)
+	m.setPosition(zoom, lat, lon, 1)
+}
+
+// Bounds returns the extent of the map visible on the canvas. If the map shows
+// more than the whole world East and West are 180 and -180.
+func (m *Map) Bounds() Bounds {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.rectBounds(0, 0, float64(m.width), float64(m.height))
+}
+
+// rectBounds returns the extent of the map visible in the rectangle from x0
+// and y0 to x1 and y1 on the canvas
+func (m *Map) rectBounds(x0, y0, x1, y1 float64) Bounds {
+	b := Bounds{North: -90, South: 90, East: math.Inf(-1), West: math.Inf(1)}
+
+	// Corners above the horizon are taken as far as the ground is drawn
+	for _, corner := range [][2]float64{{x0, y0}, {x1, y0}, {x0, y1}, {x1, y1}} {
+		gx, gy, _ := m.ground(corner[0], corner[1])
+		lat, lon := m.groundLatLon(gx, gy)
+		b.North = math.Max(b.North, lat)
+		b.South = math.Min(b.South, lat)
+		b.East = math.Max(b.East, lon)
+		b.West = math.Min(b.West, lon)
 	}
 
-	if touches.Length() == 1 {
-		m.onMouseDown(touches.Index(0))
-		return
+	north, south := LatRange(m.proj)
+	b.North = math.Min(b.North, north)
+	b.South = math.Max(b.South, south)
+
+	if !WrapsX(m.proj) {
+		return b
 	}
+	if b.East-b.West >= 360 {
+		b.East, b.West = 180, -180
+		return b
+	}
+	b.East = NormalizeLon(b.East)
+	b.West = NormalizeLon(b.West)
+	return b
+}
 
-	if touches.Length() != 2 {
-		return
+// FitBounds moves the map so bounds fills the canvas, leaving padding CSS
+// pixels around each edge. The zoom isn't taken past maxZoom or the map's
+// own zoom range; a maxZoom below the minimum zoom fits at the minimum zoom.
+// Bounds that cross the antimeridian are fitted the short way
+// round. Tilting and rotation aren't taken into account, so the fit is to the
+// ground under a flat view facing north.
+func (m *Map) FitBounds(bounds Bounds, padding, maxZoom float64) {
+	m.mu.Lock()
+	defer m.unlock()
+
+	m.stop()
+	m.fitBounds(bounds, padding, maxZoom)
+}
+
+func (m *Map) fitBounds(bounds Bounds, padding, maxZoom float64) {
+	minX, maxY := m.proj.Project(bounds.North, bounds.West)
+	maxX, minY := m.proj.Project(bounds.South, bounds.UnwrappedEast())
+
+	lat, lon := m.proj.Unproject((minX+maxX)/2, (minY+maxY)/2)
+
+	availWidth := math.Max(1, float64(m.width)-2*padding)
+	availHeight := math.Max(1, float64(m.height)-2*padding)
+
+	// The coarsest resolution that still fits the bounds on both axes
+	res := math.Max((maxX-minX)/availWidth, (maxY-minY)/availHeight)
+
+	m.setPosition(m.fitZoom(res, math.Min(maxZoom, m.maxZoom)), lat, lon, 1)
+}
+
+// fitZoom returns the deepest zoom no deeper than maxZoom where a pixel covers
+// at least res projected units, but never shallower than the minimum zoom.
+// Resolutions don't have to halve with each zoom level, so it searches rather
+// than taking a logarithm.
+func (m *Map) fitZoom(res, maxZoom float64) float64 {
+	lo, hi := m.minZoom, maxZoom
+	if hi <= lo {
+		return lo
+	}
+	if ZoomResolution(m.proj, hi) >= res {
+		return hi
+	}
+	if ZoomResolution(m.proj, lo) <= res {
+		return lo
+	}
+	for i := 0; i < 50; i++ {
+		mid := (lo + hi) / 2
+		if ZoomResolution(m.proj, mid) >= res {
+			lo = mid
+		} else {
+			hi = mid
+		}
 	}
+	return lo
+}
+
+// Projection returns the projection of the map
+func (m *Map) Projection() Projection {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	m.pinchZoomStart = m.zoom
-	m.pinchDelta = pinchDelta(touches)
-	m.pinchDown = true
+	return m.proj
 }
 
-func (m *Map) onTouchEnd(event js.Value) {
-	m.pinchDown = false
+// SetProjection sets the projection of the map. The map's URLer must serve
+// tiles in the same projection.
+func (m *Map) SetProjection(p Projection) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.proj = p
+	m.update(ZoomingZero)
 }
 
-func (m *Map) onTouchMove(event js.Value) {
-	touches := event.Get("touches")
-	if touches == js.Undefined() {
-		return
+// AddTileRenderers adds tile renderers to the map
+func (m *Map) AddTileRenderers(tr ...TileRenderer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tileRenderers = append(m.tileRenderers, tr...)
+	for _, r := range tr {
+		if l, ok := r.(TileLoader); ok {
+			l.OnLoaded(func() {
+				m.mu.Lock()
+				defer m.unlock()
+				m.checkIdle()
+			})
+		}
 	}
+}
+
+// Canvas returns the canvas the map is being drawn onto
+func (m *Map) Canvas() js.Value {
+	return m.viewport
+}
 
-	if touches.Length() == 1 {
-		m.onMouseMove(touches.Index(0))
+// Wheel events are measured in pixels, lines or pages. These convert them to
+// pixels and say how far they zoom.
+const (
+	// wheelLinePixels is how many pixels a line of scrolling is
+	wheelLinePixels = 20
+	// wheelClickPixels is how many pixels a mouse wheel click usually scrolls,
+	// which zooms WheelZoomStep
+	wheelClickPixels = 100
+	// wheelPinchZoom is how far each pixel of a trackpad pinch zooms. Browsers
+	// send pinches as wheel events with ctrl held.
+	wheelPinchZoom = 0.01
+	// wheelZoomDuration is how long the zoom from a mouse wheel click takes
+	wheelZoomDuration = 150 * time.Millisecond
+	// wheelHintDuration is how long WheelModifierHint is shown for
+	wheelHintDuration = 1500 * time.Millisecond
+)
+
+// wheelModifierFilter is the body of a JavaScript wheel listener that stops
+// the page scrolling when ctrl or cmd is held, for WheelRequiresModifier
+const wheelModifierFilter = `if (event.ctrlKey || event.metaKey) {
+	event.preventDefault();
+}`
+
+func (m *Map) wheel(event js.Value) {
+	if !m.wheelZoom {
 		return
 	}
 
-	if !m.pinchDown || touches.Length() != 2 {
+	modifier := event.Get("ctrlKey").Bool() || event.Get("metaKey").Bool()
+	if m.wheelModifier && !modifier {
+		// Let the page scroll, and say how to zoom the map instead
+		m.showWheelHint()
 		return
 	}
 
-	npd := pinchDelta(touches)
-	zoom := (m.pinchDelta - npd) / 100
-	m.SetPosition(m.pinchZoomStart-zoom, m.Lat(), m.Lon())
-}
+	px := event.Get("deltaY").Float()
+	discrete := false
+	switch event.Get("deltaMode").Int() {
+	case 1: // lines
+		px *= wheelLinePixels
+		discrete = true
+	case 2: // pages
+		px *= float64(m.height)
+		discrete = true
+	default:
+		// Mouse wheels scroll in big whole steps, trackpads in small ones
+		discrete = math.Abs(px) >= wheelClickPixels/2 && px == math.Trunc(px)
+	}
 
-func (m *Map) onMouseDown(event js.Value) {
-	m.mouseStartX = event.Get("pageX").Int()
-	m.mouseStartY = event.Get("pageY").Int()
-	m.mouseStartLat = m.Lat()
-	m.mouseStartLon = m.Lon()
-	m.mouseDown = true
-}
+	var delta float64
+	if event.Get("ctrlKey").Bool() {
+		delta = -px * wheelPinchZoom
+	} else {
+		delta = -px / wheelClickPixels * m.zoomStep
+	}
 
-func (m *Map) onMouseUp(event js.Value) {
-	m.mouseDown = false
-	m.pinchDown = false
-}
+	x, y := event.Get("offsetX").Float(), event.Get("offsetY").Float()
 
-func (m *Map) onMouseMove(event js.Value) {
-	if !m.mouseDown {
+	if !discrete {
+		// Trackpads send a stream of small deltas, follow them directly
+		m.stop()
+		m.zoomAround(m.zoom+delta, x, y)
 		return
 	}
 
-	dx := m.mouseStartX - event.Get("pageX").Int()
-	dy := m.mouseStartY - event.Get("pageY").Int()
+	// Clicks made while the last one is still zooming add to where it's going
+	target := m.zoom
+	if m.animation != nil && m.animation == m.wheelAnimation {
+		target = m.wheelTarget
+	}
+	m.wheelTarget = math.Max(m.minZoom, math.Min(m.maxZoom, target+delta))
+	m.animateZoomAround(m.wheelTarget, x, y, wheelZoomDuration)
+	m.wheelAnimation = m.animation
+}
+
+// showWheelHint shows WheelModifierHint over the map for wheelHintDuration
+func (m *Map) showWheelHint() {
+	if m.wheelHint == js.Undefined() {
+		return
+	}
+	m.wheelHint.Get("style").Set("opacity", "1")
+
+	m.wheelHintShown++
+	shown := m.wheelHintShown
+	time.AfterFunc(wheelHintDuration, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		// Leave it up if the wheel has been scrolled again since, and leave
+		// the page alone once the map is closed
+		if shown == m.wheelHintShown && !m.closed {
+			m.wheelHint.Get("style").Set("opacity", "0")
+		}
+	})
+}
 
-	lat, lon := Move(m.Zoom(), m.mouseStartLat, m.mouseStartLon, dx, dy)
-	m.SetPosition(m.Zoom(), lat, lon)
+// newWheelHint creates the element WheelModifierHint is shown in, hidden
+func newWheelHint(doc js.Value, text string) js.Value {
+	hint := doc.Call("createElement", "div")
+	hint.Set("textContent", text)
+
+	style := hint.Get("style")
+	style.Set("position", "absolute")
+	style.Set("top", "0")
+	style.Set("left", "0")
+	style.Set("width", "100%")
+	style.Set("height", "100%")
+	style.Set("display", "flex")
+	style.Set("alignItems", "center")
+	style.Set("justifyContent", "center")
+	style.Set("background", "rgba(0, 0, 0, 0.5)")
+	style.Set("color", "white")
+	style.Set("fontSize", "22px")
+	style.Set("pointerEvents", "none")
+	style.Set("opacity", "0")
+	style.Set("transition", "opacity 0.3s")
+	return hint
 }
 
 func scale(zoom float64) float64 {
@@ -370,38 +1138,106 @@ func scale(zoom float64) float64 {
 	return 1 + (0.5 + (zoom - float64(iz)))
 }
 
-// TilesFromCenter gets the tiles required from the current centre point
+// TilesFromCenter gets the tiles required from the current centre point.
+//
+// Tiles past the east and west edges of grids that span the world are repeated
+// so the map wraps around the antimeridian. Their X is left unwrapped so they
+// can be positioned, while their URL is for the wrapped column. Rows above and
+// below the grid are skipped.
+//
+// The tiles cover the ground drawn in the middle viewWidth by viewHeight
+// pixels of the canvas, which is more than that when the map is tilted or
+// rotated.
+//
+// If the map's URLer is a NativeZoomer and zoom is past its MaxNativeZoom, each
+// tile is cut out of its ancestor at MaxNativeZoom. If it is a TileSizer the
+// tiles come from the zoom level where they are drawn at that size.
 func (m *Map) TilesFromCenter(zoom float64, viewWidth, viewHeight int) map[string]*Tile {
-	tx, ty := TileNum(int(zoom), m.lat, m.lon)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.tilesFromCenter(zoom, viewWidth, viewHeight)
+}
+
+func (m *Map) tilesFromCenter(zoom float64, viewWidth, viewHeight int) map[string]*Tile {
+	tileSize := TileWidth
+	if ts, ok := m.urlEr.(TileSizer); ok {
+		tileSize = ts.TileSize()
+	}
+
+	tileZoom := TileZoom(zoom, tileSize)
+	minX, minY, maxX, maxY := m.viewExtent(zoom, viewWidth, viewHeight)
+	minCol, minRow, maxCol, maxRow := TileRange(m.proj, tileZoom, minX, minY, maxX, maxY)
+	minCol, minRow, maxCol, maxRow = minCol-1, minRow-1, maxCol+1, maxRow+1
+	_, rows := m.proj.MatrixSize(tileZoom)
+
+	nativeZoom := tileZoom
+	if nz, ok := m.urlEr.(NativeZoomer); ok && tileZoom > nz.MaxNativeZoom() {
+		nativeZoom = nz.MaxNativeZoom()
+	}
+	overzoom := 1 << uint(tileZoom-nativeZoom)
 
 	tiles := map[string]*Tile{}
 
-	requiredWidth := int(math.Ceil(float64(viewWidth)/(TileWidth))) + 2
-	requiredHeight := int(math.Ceil(float64(viewHeight)/(TileHeight))) + 2
+	for ctx := minCol; ctx <= maxCol; ctx++ {
+		col, ok := WrapCol(m.proj, tileZoom, ctx)
+		if !ok {
+			continue
+		}
+
+		for cty := minRow; cty <= maxRow; cty++ {
+			if cty < 0 || cty >= rows {
+				continue
+			}
 
-	startX := -(requiredWidth / 2)
-	startY := -(requiredHeight / 2)
-	endX := startX + requiredWidth
-	endY := startY + requiredHeight
-	for cx := startX; cx <= endX; cx++ {
-		for cy := startY; cy <= endY; cy++ {
-			ctx := cx + int(tx)
-			cty := cy + int(ty)
-			nwLat, nwLon := NW(int(zoom), ctx, cty)
+			nwLat, nwLon := TileLatLon(m.proj, tileZoom, float64(col), float64(cty))
 
 			t := &Tile{
-				URL:  m.urlEr.URL(int(zoom), ctx, cty),
-				Lat:  nwLat,
-				Lon:  nwLon,
-				Zoom: int(zoom),
+				URL:     m.urlEr.URL(nativeZoom, col/overzoom, cty/overzoom),
+				Lat:     nwLat,
+				Lon:     nwLon,
+				X:       ctx,
+				Y:       cty,
+				Zoom:    tileZoom,
+				Size:    tileSize,
+				TexX:    float64(col%overzoom) / float64(overzoom),
+				TexY:    float64(cty%overzoom) / float64(overzoom),
+				TexSize: 1 / float64(overzoom),
 			}
-			tiles[t.URL.String()] = t
+			tiles[t.Key()] = t
 		}
 	}
 
 	return tiles
 }
 
+// viewExtent returns the projected box around the ground drawn in the middle
+// viewWidth by viewHeight pixels of the canvas, with the pixels scaled to zoom.
+// Each corner is found on the ground so the box takes in the far side of a
+// tilted view and the corners of a rotated one.
+func (m *Map) viewExtent(zoom float64, viewWidth, viewHeight int) (minX, minY, maxX, maxY float64) {
+	cx, cy := m.proj.Project(m.lat, m.lon)
+	res := ZoomResolution(m.proj, zoom)
+
+	x0 := float64(m.width-viewWidth) / 2
+	y0 := float64(m.height-viewHeight) / 2
+	x1 := x0 + float64(viewWidth)
+	y1 := y0 + float64(viewHeight)
+
+	minX, minY = math.Inf(1), math.Inf(1)
+	maxX, maxY = math.Inf(-1), math.Inf(-1)
+	for _, corner := range [][2]float64{{x0, y0}, {x1, y0}, {x0, y1}, {x1, y1}} {
+		// Corners above the horizon are taken as far as the ground is drawn
+		gx, gy, _ := m.ground(corner[0], corner[1])
+
+		// Ground y is down the canvas, projected y is north
+		x, y := cx+gx*res, cy-gy*res
+		minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+		minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+	}
+	return
+}
+
 // Zooming specifies whether or not the map is currently zooming
 type Zooming byte
 
@@ -414,6 +1250,13 @@ const (
 
 // Update will repaint the map
 func (m *Map) Update(zooming Zooming) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.update(zooming)
+}
+
+func (m *Map) update(zooming Zooming) {
 	zoomStart := m.zoom
 	zoomEnd := m.zoom
 
@@ -424,18 +1267,29 @@ func (m *Map) Update(zooming Zooming) {
 		zoomEnd++
 	}
 
-	width := m.viewport.Get("width").Int()
-	height := m.viewport.Get("height").Int()
 	tiles := map[string]*Tile{}
 	for zoom := zoomStart; zoom <= zoomEnd; zoom++ {
-		ztiles := m.TilesFromCenter(zoom, int(width), int(height))
+		ztiles := m.tilesFromCenter(zoom, m.width, m.height)
 
 		for k, v := range ztiles {
 			tiles[k] = v
 		}
 	}
 
+	view := m.view()
 	for _, r := range m.tileRenderers {
-		r.RenderTiles(m.zoom, m.lat, m.lon, tiles)
+		r.RenderTiles(view, tiles)
+	}
+}
+
+// view returns where the map is, for tile renderers
+func (m *Map) view() View {
+	return View{
+		Zoom:       m.zoom,
+		Lat:        m.lat,
+		Lon:        m.lon,
+		Projection: m.proj,
+		PixelRatio: m.pixelRatio,
+		Bearing:    m.bearing,
 	}
 }
@@ -0,0 +1,447 @@
+package pichiwmap
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/gowasm/gopherwasm/js"
+)
+
+// fakeRenderer records the views it's asked to render
+type fakeRenderer struct {
+	mu    sync.Mutex
+	views []View
+}
+
+func (r *fakeRenderer) RenderTiles(view View, tiles map[string]*Tile) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.views = append(r.views, view)
+}
+
+// newTestMap creates a map without a page, drawn by a fakeRenderer
+func newTestMap() (*Map, *fakeRenderer) {
+	r := &fakeRenderer{}
+	m := &Map{
+		urlEr:        NewOpenStreetMapURLer(&url.URL{Scheme: "https", Host: "tile.example.com"}),
+		proj:         EPSG3857,
+		width:        800,
+		height:       600,
+		pixelRatio:   1,
+		zoom:         2,
+		maxZoom:      18,
+		pointers:     map[int]pointer{},
+		wheelHint:    js.Undefined(),
+		box:          js.Undefined(),
+		northControl: js.Undefined(),
+	}
+	m.AddTileRenderers(r)
+	return m, r
+}
+
+func TestSetZoomRange(t *testing.T) {
+	m, _ := newTestMap()
+	m.SetPosition(10, 10, 10)
+
+	if err := m.SetZoomRange(4, 2); err != ErrZoomRange {
+		t.Errorf("SetZoomRange(4, 2) = %v, want %v", err, ErrZoomRange)
+	}
+
+	if err := m.SetZoomRange(-1, 8); err != nil {
+		t.Fatalf("SetZoomRange(-1, 8): %v", err)
+	}
+	if min, max := m.ZoomRange(); min != 0 || max != 8 {
+		t.Errorf("ZoomRange() = %v, %v, want 0, 8", min, max)
+	}
+	if m.Zoom() != 8 {
+		t.Errorf("Zoom() = %v, want it moved into range at 8", m.Zoom())
+	}
+
+	// The map can still be moved at its zoom
+	m.SetPosition(m.Zoom(), 20, 20)
+	if m.Lat() != 20 || m.Lon() != 20 {
+		t.Errorf("position %v, %v after moving to 20, 20", m.Lat(), m.Lon())
+	}
+}
+
+func TestApplyTileJSON(t *testing.T) {
+	m, _ := newTestMap()
+
+	if err := m.ApplyTileJSON(&TileJSON{MinZoom: 5, Center: []float64{10, 20, 3}}); err != nil {
+		t.Fatal(err)
+	}
+	if min, _ := m.ZoomRange(); min != 5 {
+		t.Errorf("min zoom %v, want 5", min)
+	}
+	// The center's zoom is below the new minimum so it's moved up to it
+	if m.Zoom() != 5 || m.Lat() != 20 || m.Lon() != 10 {
+		t.Errorf("position %v, %v, %v, want 5, 20, 10", m.Zoom(), m.Lat(), m.Lon())
+	}
+
+	if err := m.ApplyTileJSON(&TileJSON{MinZoom: 20}); err != ErrZoomRange {
+		t.Errorf("ApplyTileJSON with minzoom past the max zoom = %v, want %v", err, ErrZoomRange)
+	}
+}
+
+// tiltedRenderer stretches the top half of the canvas over more ground, like a
+// camera tilted towards the horizon. Above horizon pixels down the canvas
+// there's no ground.
+type tiltedRenderer struct {
+	*fakeRenderer
+	horizon float64
+}
+
+// GroundToScreen undoes ScreenToGround. In the top half gy = -d(1 + d/150)
+// where d is how far above the middle of the canvas the pixel is.
+func (r *tiltedRenderer) GroundToScreen(view View, gx, gy float64) (sx, sy float64) {
+	if gy >= 0 {
+		return gx + 400, gy + 300
+	}
+	d := 75 * (math.Sqrt(1-gy/37.5) - 1)
+	return gx/(1+d/150) + 400, 300 - d
+}
+
+func (r *tiltedRenderer) ScreenToGround(view View, sx, sy float64) (gx, gy float64, ok bool) {
+	ok = sy >= r.horizon
+	if !ok {
+		sy = r.horizon
+	}
+	s := 1 + math.Max(0, 300-sy)/150
+	return (sx - 400) * s, (sy - 300) * s, ok
+}
+
+func TestTilesFromCenterCorners(t *testing.T) {
+	tests := []struct {
+		name    string
+		bearing float64
+		tilted  bool
+	}{
+		{"flat", 0, false},
+		{"rotated", 30, false},
+		{"tilted", 0, true},
+		{"tilted and rotated", 135, true},
+	}
+
+	for _, tt := range tests {
+		m, r := newTestMap()
+		if tt.tilted {
+			m.tileRenderers = nil
+			m.AddTileRenderers(&tiltedRenderer{fakeRenderer: r})
+		}
+		m.SetPosition(10, 45, 7)
+		m.SetBearing(tt.bearing)
+
+		if view := r.views[len(r.views)-1]; view.Zoom != 10 || view.Bearing != tt.bearing {
+			t.Errorf("%v: rendered at zoom %v bearing %v, want 10 and %v", tt.name, view.Zoom, view.Bearing, tt.bearing)
+		}
+
+		tiles := m.TilesFromCenter(10, 800, 600)
+		for _, c := range [][2]float64{{0, 0}, {800, 0}, {0, 600}, {800, 600}} {
+			lat, lon := m.Unproject(c[0], c[1])
+			x, y := TileXY(EPSG3857, 10, lat, lon)
+			key := fmt.Sprintf("10/%v/%v", int(math.Floor(x)), int(math.Floor(y)))
+			if tiles[key] == nil {
+				t.Errorf("%v: no tile %v under the canvas corner %v", tt.name, key, c)
+			}
+		}
+	}
+}
+
+func TestProjectUnproject(t *testing.T) {
+	tests := []struct {
+		name    string
+		bearing float64
+		tilted  bool
+	}{
+		{"flat", 0, false},
+		{"rotated", 30, false},
+		{"tilted", 0, true},
+		{"tilted and rotated", -120, true},
+	}
+
+	for _, tt := range tests {
+		m, r := newTestMap()
+		if tt.tilted {
+			m.tileRenderers = nil
+			m.AddTileRenderers(&tiltedRenderer{fakeRenderer: r})
+		}
+		m.SetPosition(6, -33.9, 151.2)
+		m.SetBearing(tt.bearing)
+
+		for _, p := range [][2]float64{{400, 300}, {0, 0}, {800, 0}, {120, 450}, {790, 590}} {
+			lat, lon := m.Unproject(p[0], p[1])
+			x, y := m.Project(lat, lon)
+			if math.Abs(x-p[0]) > 1e-6 || math.Abs(y-p[1]) > 1e-6 {
+				t.Errorf("%v: Project(Unproject(%v, %v)) = %v, %v", tt.name, p[0], p[1], x, y)
+			}
+		}
+
+		if lat, lon := m.Unproject(400, 300); math.Abs(lat+33.9) > 1e-9 || math.Abs(lon-151.2) > 1e-9 {
+			t.Errorf("%v: Unproject of the center = %v, %v, want -33.9, 151.2", tt.name, lat, lon)
+		}
+	}
+}
+
+func TestUnprojectSky(t *testing.T) {
+	m, r := newTestMap()
+	m.tileRenderers = nil
+	m.AddTileRenderers(&tiltedRenderer{fakeRenderer: r, horizon: 100})
+	m.SetPosition(6, 0, 0)
+
+	if lat, lon := m.Unproject(400, 50); !math.IsNaN(lat) || !math.IsNaN(lon) {
+		t.Errorf("Unproject above the horizon = %v, %v, want NaN", lat, lon)
+	}
+	if lat, lon := m.Unproject(400, 150); math.IsNaN(lat) || math.IsNaN(lon) {
+		t.Errorf("Unproject below the horizon = %v, %v", lat, lon)
+	}
+
+	// Zooming around the sky zooms around the center
+	m.SetZoomAround(7, 400, 50)
+	if lat, lon := m.Lat(), m.Lon(); math.Abs(lat) > 1e-9 || math.Abs(lon) > 1e-9 || m.Zoom() != 7 {
+		t.Errorf("SetZoomAround the sky = %v, %v zoom %v, want 0, 0 zoom 7", lat, lon, m.Zoom())
+	}
+
+	// Tiles still cover the ground up to the horizon
+	if tiles := m.TilesFromCenter(7, 800, 600); len(tiles) == 0 {
+		t.Error("no tiles for a view with sky")
+	}
+}
+
+// TestConcurrentUse moves and watches the map from several goroutines at once,
+// to be run with the race detector
+func TestConcurrentUse(t *testing.T) {
+	m, r := newTestMap()
+
+	var mu sync.Mutex
+	events := 0
+	m.On(EventMove, func(e Event) {
+		// Handlers can call back into the map
+		m.Zoom()
+
+		mu.Lock()
+		events++
+		mu.Unlock()
+	})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+
+			for i := 0; i < 50; i++ {
+				lat, lon := float64(g*5), float64(i)
+
+				switch i % 4 {
+				case 0:
+					m.SetPosition(float64(i%10), lat, lon)
+				case 1:
+					m.EaseTo(float64(i%10), lat, lon, 0, EaseOut)
+				case 2:
+					unsubscribe := m.On(EventZoom, func(e Event) {})
+					unsubscribe()
+				case 3:
+					m.SetBearing(float64(i * 10))
+				}
+
+				m.Bounds()
+				m.Project(lat, lon)
+				m.Unproject(400, 300)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if events == 0 {
+		t.Error("no move events fired")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.views) == 0 {
+		t.Error("the map was never rendered")
+	}
+	if last := r.views[len(r.views)-1]; last.Zoom != m.Zoom() || last.Lat != m.Lat() || last.Lon != m.Lon() {
+		t.Errorf("last rendered %v, %v, %v but the map is at %v, %v, %v", last.Zoom, last.Lat, last.Lon, m.Zoom(), m.Lat(), m.Lon())
+	}
+}
+
+func TestClampCenter(t *testing.T) {
+	tests := []struct {
+		c, min, max, half float64
+		want              float64
+	}{
+		{500, 0, 1000, 100, 500},
+		{50, 0, 1000, 100, 100},
+		{980, 0, 1000, 100, 900},
+		{-300, -1000, 0, 100, -300},
+		// Too small to fit is centered
+		{50, 0, 150, 100, 75},
+		{50, 0, 200, 100, 100},
+	}
+
+	for _, tt := range tests {
+		if got := clampCenter(tt.c, tt.min, tt.max, tt.half); got != tt.want {
+			t.Errorf("clampCenter(%v, %v, %v, %v) = %v, want %v", tt.c, tt.min, tt.max, tt.half, got, tt.want)
+		}
+	}
+}
+
+func TestConstrain(t *testing.T) {
+	europe := &Bounds{North: 60, South: 40, West: -10, East: 20}
+	pacific := &Bounds{North: 10, South: -10, West: 170, East: -170}
+
+	tests := []struct {
+		name           string
+		bounds         *Bounds
+		zoom, lat, lon float64
+	}{
+		{"inside", europe, 7, 50, 5},
+		{"outside", europe, 7, 0, 100},
+		{"zoomed out", europe, 2, 50, 5},
+		{"across the antimeridian east", pacific, 7, 0, 178},
+		{"across the antimeridian west", pacific, 7, 0, -178},
+		{"outside across the antimeridian", pacific, 7, 30, 0},
+	}
+
+	for _, tt := range tests {
+		m, _ := newTestMap()
+		m.maxZoom = MaxZoomLevel
+		m.maxBounds = tt.bounds
+
+		zoom, lat, lon := m.constrain(tt.zoom, tt.lat, tt.lon, 1)
+		if zoom < tt.zoom {
+			t.Errorf("%v: zoom %v went out from %v", tt.name, zoom, tt.zoom)
+		}
+
+		// The canvas is inside the bounds
+		cx, cy := WorldXY(m.proj, zoom, lat, lon)
+		minX, minY := WorldXY(m.proj, zoom, tt.bounds.North, tt.bounds.West)
+		maxX, maxY := WorldXY(m.proj, zoom, tt.bounds.South, tt.bounds.UnwrappedEast())
+		if cx < minX {
+			cx, _ = WorldXY(m.proj, zoom, lat, lon+360)
+		}
+		const e = 1e-6
+		if cx-400 < minX-e || cx+400 > maxX+e || cy-300 < minY-e || cy+300 > maxY+e {
+			t.Errorf("%v: constrain = %v, %v, %v shows %v,%v to %v,%v outside %v,%v to %v,%v",
+				tt.name, zoom, lat, lon, cx-400, cy-300, cx+400, cy+300, minX, minY, maxX, maxY)
+		}
+
+		// Already inside stays put
+		if tt.name == "inside" && (zoom != tt.zoom || math.Abs(lat-tt.lat) > e || math.Abs(lon-tt.lon) > e) {
+			t.Errorf("%v: constrain = %v, %v, %v, want it unchanged", tt.name, zoom, lat, lon)
+		}
+	}
+}
+
+func TestConstrainViscosity(t *testing.T) {
+	m, _ := newTestMap()
+	m.maxBounds = &Bounds{North: 60, South: 40, West: -10, East: 20}
+
+	if _, lat, lon := m.constrain(7, 0, 100, 0); math.Abs(lat) > 1e-9 || math.Abs(lon-100) > 1e-9 {
+		t.Errorf("constrain with viscosity 0 = %v, %v, want 0, 100", lat, lon)
+	}
+
+	// Half the viscosity goes half the way back, in pixels
+	_, lat1, lon1 := m.constrain(7, 0, 100, 1)
+	_, lat, lon := m.constrain(7, 0, 100, 0.5)
+	x0, y0 := WorldXY(m.proj, 7, 0, 100)
+	x1, y1 := WorldXY(m.proj, 7, lat1, lon1)
+	x, y := WorldXY(m.proj, 7, lat, lon)
+	if math.Abs(x-(x0+x1)/2) > 1e-6 || math.Abs(y-(y0+y1)/2) > 1e-6 {
+		t.Errorf("constrain with viscosity 0.5 = %v, %v, want halfway to %v, %v", x, y, x1, y1)
+	}
+
+	m.maxBounds = nil
+	if zoom, lat, lon := m.constrain(1, 80, 200, 1); zoom != 1 || lat != 80 || lon != 200 {
+		t.Errorf("constrain without bounds = %v, %v, %v, want it unchanged", zoom, lat, lon)
+	}
+}
+
+func TestFitZoom(t *testing.T) {
+	m, _ := newTestMap()
+	m.minZoom = 2
+
+	tests := []struct {
+		res, maxZoom float64
+		want         float64
+	}{
+		{EPSG3857.Resolution(5), 18, 5},
+		{EPSG3857.Resolution(5) * 1.5, 18, 5 - math.Log2(1.5)},
+		{EPSG3857.Resolution(5), 4, 4},
+		// Finer than the deepest zoom stops at maxZoom
+		{EPSG3857.Resolution(20), 18, 18},
+		// Coarser than the shallowest zoom stops at the minimum zoom
+		{EPSG3857.Resolution(0), 18, 2},
+		// maxZoom below the minimum zoom gives the minimum zoom
+		{EPSG3857.Resolution(5), 1, 2},
+		{EPSG3857.Resolution(5), 2, 2},
+	}
+
+	for _, tt := range tests {
+		if got := m.fitZoom(tt.res, tt.maxZoom); math.Abs(got-tt.want) > 1e-6 {
+			t.Errorf("fitZoom(%v, %v) = %v, want %v", tt.res, tt.maxZoom, got, tt.want)
+		}
+	}
+}
+
+func TestFitBounds(t *testing.T) {
+	tests := []struct {
+		name             string
+		bounds           Bounds
+		padding, maxZoom float64
+		lon              float64
+	}{
+		{"europe", Bounds{North: 60, South: 40, West: -10, East: 20}, 0, 18, 5},
+		{"padded", Bounds{North: 60, South: 40, West: -10, East: 20}, 50, 18, 5},
+		{"across the antimeridian", Bounds{North: 10, South: -10, West: 170, East: -170}, 0, 18, -180},
+	}
+
+	for _, tt := range tests {
+		m, _ := newTestMap()
+		m.FitBounds(tt.bounds, tt.padding, tt.maxZoom)
+
+		if math.Abs(m.Lon()-tt.lon) > 1e-6 {
+			t.Errorf("%v: lon = %v, want %v", tt.name, m.Lon(), tt.lon)
+		}
+
+		// The bounds fit inside the padded canvas and touch it on one axis
+		zoom := m.Zoom()
+		minX, minY := WorldXY(m.proj, zoom, tt.bounds.North, tt.bounds.West)
+		maxX, maxY := WorldXY(m.proj, zoom, tt.bounds.South, tt.bounds.UnwrappedEast())
+		w, h := maxX-minX, maxY-minY
+		availW, availH := 800-2*tt.padding, 600-2*tt.padding
+		const e = 1e-6
+		if w > availW+e || h > availH+e {
+			t.Errorf("%v: zoom %v makes the bounds %vx%v, bigger than %vx%v", tt.name, zoom, w, h, availW, availH)
+		}
+		if math.Abs(w-availW) > e && math.Abs(h-availH) > e {
+			t.Errorf("%v: zoom %v makes the bounds %vx%v, not filling %vx%v", tt.name, zoom, w, h, availW, availH)
+		}
+	}
+}
+
+func TestFitBoundsMaxZoom(t *testing.T) {
+	m, _ := newTestMap()
+	small := Bounds{North: 50.001, South: 50, West: 5, East: 5.001}
+
+	m.FitBounds(small, 0, 12)
+	if m.Zoom() != 12 {
+		t.Errorf("FitBounds with maxZoom 12 zoomed to %v", m.Zoom())
+	}
+
+	// A maxZoom below the minimum zoom still moves the map, at the minimum zoom
+	if err := m.SetZoomRange(4, 18); err != nil {
+		t.Fatal(err)
+	}
+	m.FitBounds(Bounds{North: 10, South: 0, West: 100, East: 110}, 0, 2)
+	if m.Zoom() != 4 || math.Abs(m.Lon()-105) > 1e-6 {
+		t.Errorf("FitBounds with maxZoom 2 below minimum zoom 4 = %v, %v, %v, want zoom 4 at lon 105", m.Zoom(), m.Lat(), m.Lon())
+	}
+}
@@ -0,0 +1,149 @@
+package pichiwmap
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned from Options.Validate
+var (
+	ErrZoomRange     = errors.New("min zoom must be between 0 and max zoom, and max zoom no more than MaxZoomLevel")
+	ErrZoomOutside   = errors.New("zoom must be between min zoom and max zoom")
+	ErrCenterOutside = errors.New("center must be inside max bounds")
+	ErrInvalidBounds = errors.New("max bounds north must be north of south")
+)
+
+// Options configures a Map. Start from DefaultOptions and change what's needed,
+// the zero value doesn't pass Validate.
+type Options struct {
+	// Lat, Lon and Zoom are where the map starts
+	Lat  float64
+	Lon  float64
+	Zoom float64
+	// Bearing is the compass direction at the top of the map to start with, in
+	// degrees clockwise from north
+	Bearing float64
+
+	// MinZoom and MaxZoom limit how far the map can zoom out and in
+	MinZoom float64
+	MaxZoom float64
+
+	// MaxBounds, if set, keeps the map inside the bounds
+	MaxBounds *Bounds
+	// MaxBoundsViscosity is how hard dragging past MaxBounds is resisted, from
+	// 0 (follow the pointer then spring back) to 1 (stop at the edge)
+	MaxBoundsViscosity float64
+
+	// WheelZoomStep is how far each wheel click zooms. Trackpads zoom by the
+	// same amount for each click's worth of scrolling.
+	WheelZoomStep float64
+	// WheelRequiresModifier stops the wheel zooming unless ctrl or cmd is held,
+	// so a map embedded in a page doesn't catch its scrolling. Scrolling
+	// without the key shows WheelModifierHint over the map.
+	WheelRequiresModifier bool
+	WheelModifierHint     string
+	// KeyboardPanPixels is how many CSS pixels each arrow key press pans
+	KeyboardPanPixels float64
+	// KeyboardZoomStep is how far each +/- key press zooms
+	KeyboardZoomStep float64
+	// KeyBindings are what each key does, see DefaultKeyBindings
+	KeyBindings KeyBindings
+
+	// Drag, Wheel, Keyboard and Touch turn the interactions on or off
+	Drag     bool
+	Wheel    bool
+	Keyboard bool
+	Touch    bool
+
+	// DoubleClickZoom zooms in a level on double click or double tap, and out
+	// with shift held or on a two finger tap
+	DoubleClickZoom bool
+	// BoxZoom zooms to a box dragged out with shift held
+	BoxZoom bool
+	// Rotate turns the map with a two finger twist, or a drag with the right
+	// mouse button or ctrl held
+	Rotate bool
+	// ResetNorthControl shows a button that turns the map back to north while
+	// it's rotated
+	ResetNorthControl bool
+
+	// Inertia keeps the map gliding after a drag is released. The glide starts
+	// no faster than InertiaMaxSpeed CSS pixels a second and slows
+	// exponentially, InertiaDeceleration is how many times e its speed drops
+	// by each second.
+	Inertia             bool
+	InertiaMaxSpeed     float64
+	InertiaDeceleration float64
+
+	// BackgroundColor is the CSS color shown where there are no tiles
+	BackgroundColor string
+}
+
+// DefaultOptions returns options for a map showing the whole world with every
+// interaction turned on
+func DefaultOptions() Options {
+	return Options{
+		Lat:                 0,
+		Lon:                 0,
+		Zoom:                2,
+		MinZoom:             0,
+		MaxZoom:             18,
+		MaxBoundsViscosity:  1,
+		WheelZoomStep:       0.1,
+		WheelModifierHint:   "Use ctrl + scroll to zoom the map",
+		KeyboardPanPixels:   80,
+		KeyboardZoomStep:    1,
+		KeyBindings:         DefaultKeyBindings(),
+		Drag:                true,
+		Wheel:               true,
+		Keyboard:            true,
+		Touch:               true,
+		DoubleClickZoom:     true,
+		BoxZoom:             true,
+		Rotate:              true,
+		ResetNorthControl:   true,
+		Inertia:             true,
+		InertiaMaxSpeed:     3000,
+		InertiaDeceleration: 4,
+	}
+}
+
+// Validate returns an error if the options can't be used together
+func (o Options) Validate() error {
+	if o.MinZoom < 0 || o.MinZoom > o.MaxZoom || o.MaxZoom > MaxZoomLevel {
+		return ErrZoomRange
+	}
+	if o.Zoom < o.MinZoom || o.Zoom > o.MaxZoom {
+		return ErrZoomOutside
+	}
+	if o.Lat < -90 || o.Lat > 90 {
+		return fmt.Errorf("latitude %v must be between -90 and 90", o.Lat)
+	}
+	if o.WheelZoomStep <= 0 {
+		return fmt.Errorf("wheel zoom step %v must be positive", o.WheelZoomStep)
+	}
+	if o.KeyboardPanPixels <= 0 {
+		return fmt.Errorf("keyboard pan %v must be positive", o.KeyboardPanPixels)
+	}
+	if o.KeyboardZoomStep <= 0 {
+		return fmt.Errorf("keyboard zoom step %v must be positive", o.KeyboardZoomStep)
+	}
+	if o.Inertia && o.InertiaMaxSpeed <= 0 {
+		return fmt.Errorf("inertia max speed %v must be positive", o.InertiaMaxSpeed)
+	}
+	if o.Inertia && o.InertiaDeceleration <= 0 {
+		return fmt.Errorf("inertia deceleration %v must be positive", o.InertiaDeceleration)
+	}
+	if o.MaxBoundsViscosity < 0 || o.MaxBoundsViscosity > 1 {
+		return fmt.Errorf("max bounds viscosity %v must be between 0 and 1", o.MaxBoundsViscosity)
+	}
+	if o.MaxBounds != nil {
+		if o.MaxBounds.South >= o.MaxBounds.North {
+			return ErrInvalidBounds
+		}
+		if !o.MaxBounds.Contains(o.Lat, o.Lon) {
+			return ErrCenterOutside
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package pichiwmap
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	if err := DefaultOptions().Validate(); err != nil {
+		t.Fatalf("DefaultOptions().Validate() = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		change   func(o *Options)
+		err      error
+		contains string
+	}{
+		{"zero value", func(o *Options) { *o = Options{} }, nil, "wheel zoom step 0 must be positive"},
+		{"negative min zoom", func(o *Options) { o.MinZoom = -1 }, ErrZoomRange, ""},
+		{"min zoom past max zoom", func(o *Options) { o.MinZoom, o.MaxZoom = 10, 5 }, ErrZoomRange, ""},
+		{"max zoom past MaxZoomLevel", func(o *Options) { o.MaxZoom = MaxZoomLevel + 1 }, ErrZoomRange, ""},
+		{"zoom under min zoom", func(o *Options) { o.MinZoom, o.Zoom = 3, 2 }, ErrZoomOutside, ""},
+		{"zoom over max zoom", func(o *Options) { o.Zoom = 19 }, ErrZoomOutside, ""},
+		{"latitude past the pole", func(o *Options) { o.Lat = 91 }, nil, "latitude 91 must be between -90 and 90"},
+		{"latitude past the south pole", func(o *Options) { o.Lat = -91 }, nil, "latitude -91 must be between -90 and 90"},
+		{"wheel zoom step", func(o *Options) { o.WheelZoomStep = -0.1 }, nil, "wheel zoom step -0.1 must be positive"},
+		{"keyboard pan", func(o *Options) { o.KeyboardPanPixels = 0 }, nil, "keyboard pan 0 must be positive"},
+		{"keyboard zoom step", func(o *Options) { o.KeyboardZoomStep = 0 }, nil, "keyboard zoom step 0 must be positive"},
+		{"inertia max speed", func(o *Options) { o.InertiaMaxSpeed = 0 }, nil, "inertia max speed 0 must be positive"},
+		{"inertia deceleration", func(o *Options) { o.InertiaDeceleration = -1 }, nil, "inertia deceleration -1 must be positive"},
+		{"viscosity under 0", func(o *Options) { o.MaxBoundsViscosity = -0.5 }, nil, "max bounds viscosity -0.5 must be between 0 and 1"},
+		{"viscosity over 1", func(o *Options) { o.MaxBoundsViscosity = 2 }, nil, "max bounds viscosity 2 must be between 0 and 1"},
+		{"bounds upside down", func(o *Options) { o.MaxBounds = &Bounds{North: 10, South: 20, West: 0, East: 10} }, ErrInvalidBounds, ""},
+		{"bounds with no height", func(o *Options) { o.MaxBounds = &Bounds{North: 10, South: 10, West: 0, East: 10} }, ErrInvalidBounds, ""},
+		{"center outside bounds", func(o *Options) { o.MaxBounds = &Bounds{North: 60, South: 40, West: -10, East: 20} }, ErrCenterOutside, ""},
+		{"center outside bounds across the antimeridian", func(o *Options) {
+			o.MaxBounds = &Bounds{North: 10, South: -10, West: 170, East: -170}
+		}, ErrCenterOutside, ""},
+	}
+
+	for _, tt := range tests {
+		o := DefaultOptions()
+		tt.change(&o)
+		err := o.Validate()
+		switch {
+		case err == nil:
+			t.Errorf("%v: Validate() succeeded, want an error", tt.name)
+		case tt.err != nil && err != tt.err:
+			t.Errorf("%v: Validate() = %v, want %v", tt.name, err, tt.err)
+		case tt.contains != "" && !strings.Contains(err.Error(), tt.contains):
+			t.Errorf("%v: Validate() = %v, want it to contain %q", tt.name, err, tt.contains)
+		}
+	}
+
+	// Inertia settings only matter with inertia on, and centers inside bounds
+	// across the antimeridian are fine
+	o := DefaultOptions()
+	o.Inertia, o.InertiaMaxSpeed, o.InertiaDeceleration = false, 0, 0
+	o.Lon = 175
+	o.MaxBounds = &Bounds{North: 10, South: -10, West: 170, East: -170}
+	if err := o.Validate(); err != nil {
+		t.Errorf("Validate() = %v, want no error", err)
+	}
+}
@@ -18,26 +18,33 @@ var unitSquare = []float32{
 	1, 0,
 }
 
+// tileSquare is scaled to the size each tile is drawn at
 var tileSquare = []float32{
 	0, 0, 0,
-	0, pichiwmap.TileHeight, 0,
-	pichiwmap.TileWidth, 0, 0,
+	0, 1, 0,
+	1, 0, 0,
 
-	0, pichiwmap.TileHeight, 0,
-	pichiwmap.TileWidth, pichiwmap.TileHeight, 0,
-	pichiwmap.TileWidth, 0, 0,
+	0, 1, 0,
+	1, 1, 0,
+	1, 0, 0,
 }
 
 var fov = 60 * math.Pi / 180
 
 // NewTileRenderer creates a new tile renderer
 func NewTileRenderer(canvasEl js.Value) (*TileRenderer, error) {
-	cache, err := lru.New(1000)
+	gl, err := NewWebGL(canvasEl)
 	if err != nil {
 		return nil, err
 	}
 
-	gl, err := NewWebGL(canvasEl)
+	// Textures dropped from the cache, whether evicted, cancelled or purged on
+	// close, are done with so their callbacks and GL texture are freed
+	cache, err := lru.NewWithEvict(1000, func(key, value interface{}) {
+		txi := value.(*textureInfo)
+		txi.Release()
+		gl.DeleteTexture(txi.Texture)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -52,6 +59,7 @@ func NewTileRenderer(canvasEl js.Value) (*TileRenderer, error) {
 
 	matrixLocation := gl.GetUniformLocation(program, "u_matrix")
 	textureLocation := gl.GetUniformLocation(program, "u_texture")
+	texrectLocation := gl.GetUniformLocation(program, "u_texrect")
 
 	markerProgram, err := gl.CreateProgramFromSource(markerVertexShaderSource, markerFragmentShaderSource)
 	if err != nil {
@@ -89,10 +97,17 @@ func NewTileRenderer(canvasEl js.Value) (*TileRenderer, error) {
 		markerBuffer:   markerBuffer,
 		matrix:         matrixLocation,
 		texture:        textureLocation,
+		texrect:        texrectLocation,
 		cache:          cache,
 	}
 
-	t.renderFrame = js.NewCallback(func(args []js.Value) { t.updateGl() })
+	t.renderFrame = js.NewCallback(func(args []js.Value) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		t.framePending = false
+		t.updateGl()
+	})
 
 	return t, nil
 }
@@ -113,13 +128,23 @@ type TileRenderer struct {
 	markerBuffer   js.Value
 	markerTexture  js.Value
 
-	texture     js.Value
-	zoom        float64
-	lat         float64
-	lon         float64
+	texture js.Value
+	texrect js.Value
+
+	// mu guards everything below. The map renders with its own lock held, so
+	// the map mustn't be called into with mu held.
+	mu          sync.Mutex
+	view        pichiwmap.View
 	toDraw      []*drawInfo
 	cache       *lru.Cache
 	renderFrame js.Callback
+	onLoaded    func()
+
+	// frameID is the pending animation frame, so only one is requested at a
+	// time and it can be cancelled
+	frameID      js.Value
+	framePending bool
+	closed       bool
 }
 
 // Viewport returns the current width and height of the tile renderer's viewport
@@ -131,66 +156,145 @@ func (t *TileRenderer) Viewport() (width, height float64) {
 
 var up = Coord{X: 0, Y: -1, Z: 0}
 
-func (t *TileRenderer) updateGl() {
+// tilt is how far the camera leans back from looking straight down at the map
+var tilt = math.Pi / 4
+
+// viewProjection returns the matrix that takes ground coordinates, in pixels
+// relative to the center of the map at view, to clip space
+func (t *TileRenderer) viewProjection(view pichiwmap.View) Matrix4 {
 	cWidth, cHeight := t.Viewport()
-	t.gl.Viewport(0, 0, cWidth, cHeight)
 
-	t.gl.Enable(t.gl.CullFace)
-	t.gl.Enable(t.gl.DepthTest)
+	pixelRatio := view.PixelRatio
+	if pixelRatio == 0 {
+		pixelRatio = 1
+	}
 
-	t.gl.Clear(t.gl.ColorBufferBit | t.gl.DepthBufferBit)
+	// At this distance one pixel on the ground under the target is one CSS pixel
+	// on the screen
+	distance := cHeight / pixelRatio / 2 / math.Tan(fov/2)
 
-	aspect := cWidth / cHeight
+	projection := Perspective(float32(fov), float32(cWidth/cHeight), float32(distance/100), float32(distance*10))
 
-	projection := Perspective(float32(fov), float32(aspect), 1, 2000)
+	cameraPosition := Coord{
+		X: 0,
+		Y: float32(distance * math.Sin(tilt)),
+		Z: float32(-distance * math.Cos(tilt)),
+	}
 
-	x, y := pichiwmap.TileNum(int(t.zoom), t.lat, t.lon)
+	camera := LookAt(cameraPosition, Coord{}, up)
 
-	x *= pichiwmap.TileWidth
-	y *= pichiwmap.TileHeight
+	cameraView := camera.Inverse()
 
-	z := 1000 - (t.zoom * 100)
+	// The ground turns under the camera, anticlockwise as the bearing goes up
+	// so the bearing ends up at the top
+	return projection.Multiply(cameraView).ZRotate(-view.Bearing * math.Pi / 180)
+}
 
-	cameraPosition := Identity().
-		XRotate(math.Pi / 4).
-		TransformVector(Coord{X: 0, Y: 0, Z: (-100 * float32(z)) - 400})
+// cssSize returns the size of the canvas in CSS pixels at view's pixel ratio
+func (t *TileRenderer) cssSize(view pichiwmap.View) (width, height float64) {
+	width, height = t.Viewport()
+	if view.PixelRatio != 0 {
+		width /= view.PixelRatio
+		height /= view.PixelRatio
+	}
+	return
+}
 
-	cameraPosition.X += float32(x)
-	cameraPosition.Y += float32(y)
+// GroundToScreen converts a point on the ground, in pixels from the center of
+// the map at view, to CSS pixels from the top left of the canvas
+func (t *TileRenderer) GroundToScreen(view pichiwmap.View, gx, gy float64) (sx, sy float64) {
+	clip := t.viewProjection(view).TransformVector(Coord{X: float32(gx), Y: float32(gy), Z: 0, W: 1})
+	width, height := t.cssSize(view)
+	sx = (float64(clip.X/clip.W) + 1) / 2 * width
+	sy = (1 - float64(clip.Y/clip.W)) / 2 * height
+	return
+}
 
-	target := Coord{X: float32(x), Y: float32(y), Z: 0}
+// ScreenToGround converts CSS pixels from the top left of the canvas to the
+// point on the ground drawn there, in pixels from the center of the map at
+// view. The ray through the pixel is cast from the near plane to the far plane
+// and intersected with the ground. If it misses, false is returned with the
+// point where the ray leaves the far plane.
+func (t *TileRenderer) ScreenToGround(view pichiwmap.View, sx, sy float64) (gx, gy float64, ok bool) {
+	width, height := t.cssSize(view)
+	nx := float32(sx/width*2 - 1)
+	ny := float32(1 - sy/height*2)
+
+	inverse := t.viewProjection(view).Inverse()
+	near := unprojectClip(inverse, nx, ny, -1)
+	far := unprojectClip(inverse, nx, ny, 1)
+
+	// Looking along the ground there is nothing to hit, and rays above the
+	// horizon hit the ground behind the camera or past the far plane
+	dz := far.Z - near.Z
+	if dz == 0 {
+		return float64(far.X), float64(far.Y), false
+	}
+	along := -near.Z / dz
+	if along < 0 || along > 1 {
+		return float64(far.X), float64(far.Y), false
+	}
 
-	camera := LookAt(cameraPosition, target, up)
+	gx = float64(near.X + (far.X-near.X)*along)
+	gy = float64(near.Y + (far.Y-near.Y)*along)
+	return gx, gy, true
+}
 
-	view := camera.Inverse()
-	viewProjection := projection.Multiply(view)
+func unprojectClip(inverse Matrix4, x, y, z float32) Coord {
+	c := inverse.TransformVector(Coord{X: x, Y: y, Z: z, W: 1})
+	return Coord{X: c.X / c.W, Y: c.Y / c.W, Z: c.Z / c.W}
+}
+
+// updateGl draws the tiles. t must be locked.
+func (t *TileRenderer) updateGl() {
+	cWidth, cHeight := t.Viewport()
+	t.gl.Viewport(0, 0, cWidth, cHeight)
+
+	t.gl.Enable(t.gl.CullFace)
+	t.gl.Enable(t.gl.DepthTest)
+
+	t.gl.Clear(t.gl.ColorBufferBit | t.gl.DepthBufferBit)
+
+	viewProjection := t.viewProjection(t.view)
 
-	t.drawMarker(
-		viewProjection,
-		float32(x),
-		float32(y),
-	)
+	t.drawMarker(viewProjection, 0, 0)
+
+	// Tiles are drawn relative to the center so float32 keeps its precision at
+	// high zoom levels
+	cx, cy := pichiwmap.WorldXY(t.view.Projection, t.view.Zoom, t.view.Lat, t.view.Lon)
 
 	for _, td := range t.toDraw {
-		x, y := pichiwmap.TileNum(int(t.zoom), td.DX, td.DY)
+		// A tile covers the same ground whatever its pixel size, so it's drawn as
+		// big as a TileWidth tile from its zoom level would be
+		scale := pichiwmap.ZoomScale(t.view.Projection, t.view.Zoom, td.Zoom)
 
 		t.drawImage(
 			viewProjection,
 			td.Texture,
-			float32(x*pichiwmap.TileWidth),
-			float32(y*pichiwmap.TileHeight),
+			float32(float64(td.X)*pichiwmap.TileWidth*scale-cx),
+			float32(float64(td.Y)*pichiwmap.TileHeight*scale-cy),
+			float32(pichiwmap.TileWidth*scale),
+			td,
 		)
 	}
 }
 
 // RenderTiles will render the given tiles at the current zoom level
-func (t *TileRenderer) RenderTiles(zoom, lat, lon float64, tiles map[string]*pichiwmap.Tile) {
-	t.zoom = zoom
-	t.lat = lat
-	t.lon = lon
-	// Cancel any loads that are no longer necessary
+func (t *TileRenderer) RenderTiles(view pichiwmap.View, tiles map[string]*pichiwmap.Tile) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.view = view
+	// Tiles are keyed by position, textures by URL since world copies share them
+	urls := map[string]bool{}
+	for _, tile := range tiles {
+		urls[tile.URL.String()] = true
+	}
+
+	// Cancel any loads that are no longer necessary. Removing them from the
+	// cache releases them.
 	for _, td := range t.toDraw {
-		if _, ok := tiles[td.Texture.URL]; !ok {
+		if !urls[td.Texture.URL] {
 			if td.Texture.Cancel() {
 				t.cache.Remove(td.Texture.URL)
 			}
@@ -211,11 +315,25 @@ func (t *TileRenderer) RenderTiles(zoom, lat, lon float64, tiles map[string]*pic
 			t.cache.Add(u, txi)
 		}
 
-		if tile.Zoom == int(zoom) {
+		tileSize := tile.Size
+		if tileSize == 0 {
+			tileSize = pichiwmap.TileWidth
+		}
+
+		if tile.Zoom == pichiwmap.TileZoom(view.Zoom, tileSize) {
+			texSize := tile.TexSize
+			if texSize == 0 {
+				texSize = 1
+			}
+
 			t.toDraw = append(t.toDraw, &drawInfo{
 				Texture: txi,
-				DX:      tile.Lat,
-				DY:      tile.Lon,
+				X:       tile.X,
+				Y:       tile.Y,
+				Zoom:    tile.Zoom,
+				TexX:    float32(tile.TexX),
+				TexY:    float32(tile.TexY),
+				TexSize: float32(texSize),
 			})
 		}
 	}
@@ -223,18 +341,93 @@ func (t *TileRenderer) RenderTiles(zoom, lat, lon float64, tiles map[string]*pic
 }
 
 func (t *TileRenderer) imageLoadCallback(txi *textureInfo) {
+	t.mu.Lock()
 	t.requestAnimationFrame()
+	onLoaded := t.onLoaded
+	t.mu.Unlock()
+
+	// Called unlocked as it calls back into the map
+	if onLoaded != nil {
+		onLoaded()
+	}
 }
 
+// Loading returns true while any of the tiles being drawn are still loading
+func (t *TileRenderer) Loading() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, td := range t.toDraw {
+		if !td.Texture.Done() {
+			return true
+		}
+	}
+	return false
+}
+
+// OnLoaded sets f to be called whenever a tile finishes loading, or fails to
+func (t *TileRenderer) OnLoaded(f func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.onLoaded = f
+}
+
+// requestAnimationFrame asks for a frame to draw the tiles in, unless one
+// already has been. t must be locked.
 func (t *TileRenderer) requestAnimationFrame() {
-	js.Global().Call("requestAnimationFrame", t.renderFrame)
+	if t.framePending || t.closed {
+		return
+	}
+	t.framePending = true
+	t.frameID = js.Global().Call("requestAnimationFrame", t.renderFrame)
+}
+
+// Close stops rendering, cancels any tile loads and releases the renderer's
+// callbacks and WebGL resources. The renderer can't be used afterwards.
+func (t *TileRenderer) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return
+	}
+	t.closed = true
+
+	if t.framePending {
+		js.Global().Call("cancelAnimationFrame", t.frameID)
+		t.framePending = false
+	}
+	t.renderFrame.Release()
+
+	t.cache.Purge()
+	t.toDraw = nil
+	t.onLoaded = nil
+
+	t.gl.DeleteBuffer(t.squareBuffer)
+	t.gl.DeleteBuffer(t.texcoordBuffer)
+	t.gl.DeleteBuffer(t.markerBuffer)
+	t.gl.DeleteTexture(t.markerTexture)
+	t.deleteProgram(t.program)
+	t.deleteProgram(t.markerProgram)
+}
+
+// deleteProgram deletes program and the shaders it was linked from
+func (t *TileRenderer) deleteProgram(program js.Value) {
+	for _, shader := range t.gl.GetAttachedShaders(program) {
+		t.gl.DetachShader(program, shader)
+		t.gl.DeleteShader(shader)
+	}
+	t.gl.DeleteProgram(program)
 }
 
 func (t *TileRenderer) drawImage(
 	viewProjection Matrix4,
 	tex *textureInfo,
 	dstX,
-	dstY float32,
+	dstY,
+	size float32,
+	td *drawInfo,
 ) {
 	t.gl.UseProgram(t.program)
 
@@ -246,10 +439,11 @@ func (t *TileRenderer) drawImage(
 	t.gl.BindBuffer(t.gl.ArrayBuffer, t.texcoordBuffer)
 	t.gl.VertexAttribPointer(t.texcoord, 2, t.gl.Float, false, 0, 0)
 
-	matrix := viewProjection.Translate(dstX, dstY, 0)
+	matrix := viewProjection.Translate(dstX, dstY, 0).Scale(size, size, 1)
 
 	t.gl.BindTexture(t.gl.Texture2D, tex.Texture)
 	t.gl.Uniform1i(t.texture, 0)
+	t.gl.Uniform4f(t.texrect, td.TexX, td.TexY, td.TexSize, td.TexSize)
 	t.gl.UniformMatrix4fv(t.matrix, false, matrix)
 	t.gl.DrawArrays(t.gl.Triangles, 0, 6)
 }
@@ -278,7 +472,30 @@ type textureInfo struct {
 	Texture   js.Value
 	Image     js.Value
 	Loaded    bool
+	Failed    bool
 	Cancelled bool
+
+	onLoad  js.Callback
+	onError js.Callback
+}
+
+// Done returns true once the image has loaded, failed or been cancelled
+func (t *textureInfo) Done() bool {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	return t.Loaded || t.Failed || t.Cancelled
+}
+
+// Release cancels the load if it's still going and releases the image's
+// callbacks
+func (t *textureInfo) Release() {
+	t.Cancel()
+
+	t.Image.Call("removeEventListener", "load", t.onLoad)
+	t.Image.Call("removeEventListener", "error", t.onError)
+	t.onLoad.Release()
+	t.onError.Release()
 }
 
 func (t *textureInfo) Cancel() bool {
@@ -326,35 +543,53 @@ func (t *TileRenderer) loadImage(url string, onLoad func(txi *textureInfo)) *tex
 		Image:   js.Global().Get("Image").New(),
 	}
 
-	txi.Image.Call("addEventListener", "load", js.NewEventCallback(0, func(event js.Value) {
+	txi.onLoad = js.NewEventCallback(0, func(event js.Value) {
+		t.imageLoaded(txi)
+		onLoad(txi)
+	})
+	txi.onError = js.NewEventCallback(0, func(event js.Value) {
 		txi.m.Lock()
-		defer txi.m.Unlock()
+		txi.Failed = true
+		txi.m.Unlock()
 
-		txi.Loaded = true
-
-		txi.Width = txi.Image.Get("width").Int()
-		txi.Height = txi.Image.Get("height").Int()
-
-		t.gl.BindTexture(t.gl.Texture2D, txi.Texture)
-		t.gl.TexImage2DData(t.gl.Texture2D, 0, t.gl.RGBA, t.gl.RGBA, t.gl.UnsignedByte, txi.Image)
-		if powerOfTwo(txi.Width) && powerOfTwo(txi.Height) {
-			t.gl.GenerateMipmap(t.gl.Texture2D)
-		} else {
-			t.gl.TexParameteri(t.gl.Texture2D, t.gl.TextureWrapS, t.gl.ClampToEdge)
-			t.gl.TexParameteri(t.gl.Texture2D, t.gl.TextureWrapT, t.gl.ClampToEdge)
-			t.gl.TexParameteri(t.gl.Texture2D, t.gl.TextureMinFilter, t.gl.Linear)
-		}
+		// The blank texture stays in place of the missing tile
 		onLoad(txi)
-	}))
+	})
+	txi.Image.Call("addEventListener", "load", txi.onLoad)
+	txi.Image.Call("addEventListener", "error", txi.onError)
 	txi.Image.Set("crossOrigin", "")
 	txi.Image.Set("src", url)
 	return txi
 }
 
+func (t *TileRenderer) imageLoaded(txi *textureInfo) {
+	txi.m.Lock()
+	defer txi.m.Unlock()
+
+	txi.Loaded = true
+
+	txi.Width = txi.Image.Get("width").Int()
+	txi.Height = txi.Image.Get("height").Int()
+
+	t.gl.BindTexture(t.gl.Texture2D, txi.Texture)
+	t.gl.TexImage2DData(t.gl.Texture2D, 0, t.gl.RGBA, t.gl.RGBA, t.gl.UnsignedByte, txi.Image)
+	if powerOfTwo(txi.Width) && powerOfTwo(txi.Height) {
+		t.gl.GenerateMipmap(t.gl.Texture2D)
+	} else {
+		t.gl.TexParameteri(t.gl.Texture2D, t.gl.TextureWrapS, t.gl.ClampToEdge)
+		t.gl.TexParameteri(t.gl.Texture2D, t.gl.TextureWrapT, t.gl.ClampToEdge)
+		t.gl.TexParameteri(t.gl.Texture2D, t.gl.TextureMinFilter, t.gl.Linear)
+	}
+}
+
 type drawInfo struct {
 	Texture *textureInfo
-	DX      float64
-	DY      float64
+	X       int
+	Y       int
+	Zoom    int
+	TexX    float32
+	TexY    float32
+	TexSize float32
 }
 
 const tileVertexShaderSource = `
@@ -362,12 +597,13 @@ attribute vec4 a_position;
 attribute vec2 a_texcoord;
  
 uniform mat4 u_matrix;
+uniform vec4 u_texrect;
  
 varying vec2 v_texcoord;
  
 void main() {
    gl_Position = u_matrix * a_position;
-   v_texcoord = a_texcoord;
+   v_texcoord = u_texrect.xy + a_texcoord * u_texrect.zw;
 }
 `
 
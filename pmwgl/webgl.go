@@ -116,12 +116,44 @@ func (w *WebGL) CreateProgram() js.Value {
 	return w.gl.Call("createProgram")
 }
 
+// DeleteProgram https://developer.mozilla.org/en-US/docs/Web/API/WebGLRenderingContext/deleteProgram
+// void gl.deleteProgram(program);
+func (w *WebGL) DeleteProgram(program js.Value) {
+	w.gl.Call("deleteProgram", program)
+}
+
 // AttachShader https://developer.mozilla.org/en-US/docs/Web/API/WebGLRenderingContext/attachShader
 // void gl.attachShader(program, shader);
 func (w *WebGL) AttachShader(program, shader js.Value) {
 	w.gl.Call("attachShader", program, shader)
 }
 
+// DetachShader https://developer.mozilla.org/en-US/docs/Web/API/WebGLRenderingContext/detachShader
+// void gl.detachShader(program, shader);
+func (w *WebGL) DetachShader(program, shader js.Value) {
+	w.gl.Call("detachShader", program, shader)
+}
+
+// DeleteShader https://developer.mozilla.org/en-US/docs/Web/API/WebGLRenderingContext/deleteShader
+// void gl.deleteShader(shader);
+func (w *WebGL) DeleteShader(shader js.Value) {
+	w.gl.Call("deleteShader", shader)
+}
+
+// GetAttachedShaders https://developer.mozilla.org/en-US/docs/Web/API/WebGLRenderingContext/getAttachedShaders
+// WebGLShader[] gl.getAttachedShaders(program);
+func (w *WebGL) GetAttachedShaders(program js.Value) []js.Value {
+	list := w.gl.Call("getAttachedShaders", program)
+	if list == js.Null() || list == js.Undefined() {
+		return nil
+	}
+	shaders := make([]js.Value, list.Length())
+	for i := range shaders {
+		shaders[i] = list.Index(i)
+	}
+	return shaders
+}
+
 // LinkProgram https://developer.mozilla.org/en-US/docs/Web/API/WebGLRenderingContext/linkProgram
 // void gl.linkProgram(program);
 func (w *WebGL) LinkProgram(program js.Value) {
@@ -164,6 +196,12 @@ func (w *WebGL) CreateBuffer() js.Value {
 	return w.gl.Call("createBuffer")
 }
 
+// DeleteBuffer https://developer.mozilla.org/en-US/docs/Web/API/WebGLRenderingContext/deleteBuffer
+// void gl.deleteBuffer(buffer);
+func (w *WebGL) DeleteBuffer(buffer js.Value) {
+	w.gl.Call("deleteBuffer", buffer)
+}
+
 // BindBuffer https://developer.mozilla.org/en-US/docs/Web/API/WebGLRenderingContext/bindBuffer
 // void gl.bindBuffer(target, buffer);
 func (w *WebGL) BindBuffer(t int, buffer js.Value) {
@@ -242,6 +280,12 @@ func (w *WebGL) Uniform1i(location js.Value, v0 float64) {
 	w.gl.Call("uniform1i", location, v0)
 }
 
+// Uniform4f https://developer.mozilla.org/en-US/docs/Web/API/WebGLRenderingContext/uniform
+// void gl.uniform4f(location, v0, v1, v2, v3);
+func (w *WebGL) Uniform4f(location js.Value, v0, v1, v2, v3 float32) {
+	w.gl.Call("uniform4f", location, v0, v1, v2, v3)
+}
+
 // DrawArrays https://developer.mozilla.org/en-US/docs/Web/API/WebGLRenderingContext/drawArrays
 // void gl.drawArrays(mode, first, count);
 func (w *WebGL) DrawArrays(mode, first, count int) {
@@ -254,6 +298,12 @@ func (w *WebGL) CreateTexture() js.Value {
 	return w.gl.Call("createTexture")
 }
 
+// DeleteTexture https://developer.mozilla.org/en-US/docs/Web/API/WebGLRenderingContext/deleteTexture
+// void gl.deleteTexture(texture);
+func (w *WebGL) DeleteTexture(texture js.Value) {
+	w.gl.Call("deleteTexture", texture)
+}
+
 // TexImage2DColor https://developer.mozilla.org/en-US/docs/Web/API/WebGLRenderingContext/texImage2D
 // void gl.texImage2D(target, level, internalformat, width, height, border, format, type, ArrayBufferView? pixels);
 func (w *WebGL) TexImage2DColor(target int, level float64, internalformat int, width, height, border float64, format int, typ int, source js.TypedArray) {
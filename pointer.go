@@ -0,0 +1,355 @@
+package pichiwmap
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gowasm/gopherwasm/js"
+)
+
+// clickTolerance is how far in CSS pixels the pointer can move between pressing
+// and releasing for it to still be a click rather than a drag
+const clickTolerance = 3
+
+// Taps are told apart from drags by how long they take and, for double taps,
+// how close together they are
+const (
+	// tapDuration is the longest a tap can be held for, in milliseconds
+	tapDuration = 300
+	// doubleTapInterval is the longest time between the taps of a double tap
+	doubleTapInterval = 300
+	// doubleTapDistance is how far apart the taps of a double tap can be
+	doubleTapDistance = 30
+	// tapZoomDuration is how long zooming from a double click or tap takes
+	tapZoomDuration = 250 * time.Millisecond
+)
+
+// pointer is where a pointer that's down is on the canvas
+type pointer struct {
+	x, y float64
+}
+
+// pointerXY returns where a pointer event happened on the canvas
+func (m *Map) pointerXY(event js.Value) (x, y float64) {
+	return m.canvasXY(event.Get("clientX").Float(), event.Get("clientY").Float())
+}
+
+func (m *Map) onPointerDown(event js.Value) {
+	m.pointerType = event.Get("pointerType").String()
+	if m.pointerType == "touch" && !m.touch {
+		return
+	}
+	// The main mouse button moves the map, the right one or ctrl turns it
+	button := event.Get("button").Int()
+	turn := m.rotate && m.pointerType == "mouse" && (button == 2 || button == 0 && held(event, "ctrlKey"))
+	if m.pointerType == "mouse" && button != 0 && !turn {
+		return
+	}
+
+	// The pointer down is prevented so it doesn't select text, which also
+	// stops the container taking focus
+	m.container.Call("focus")
+
+	id := event.Get("pointerId").Int()
+	m.viewport.Call("setPointerCapture", id)
+	m.stop()
+
+	if len(m.pointers) == 0 {
+		m.startGesture()
+	}
+	x, y := m.pointerXY(event)
+	m.pointers[id] = pointer{x: x, y: y}
+
+	switch len(m.pointers) {
+	case 1:
+		if turn {
+			m.startTurn(x)
+			return
+		}
+		if m.boxZoom && held(event, "shiftKey") {
+			m.startBox(x, y)
+			return
+		}
+		m.tapping = true
+		m.tapStartTime = now()
+		m.tapX, m.tapY = x, y
+		m.startDrag(x, y)
+	case 2:
+		m.tapping = false
+		for other := range m.pointers {
+			if other != id {
+				m.pinchIDs = [2]int{other, id}
+			}
+		}
+		if !m.boxDown {
+			m.startPinch()
+		}
+	}
+}
+
+func (m *Map) onPointerMove(event js.Value) {
+	id := event.Get("pointerId").Int()
+	if _, ok := m.pointers[id]; !ok {
+		return
+	}
+	x, y := m.pointerXY(event)
+	m.pointers[id] = pointer{x: x, y: y}
+
+	if math.Hypot(x-m.tapX, y-m.tapY) > clickTolerance {
+		m.tapping = false
+	}
+
+	switch {
+	case m.boxDown:
+		m.moveBox(x, y)
+	case m.turning:
+		m.moveTurn(x)
+	case m.pinching:
+		m.movePinch()
+	case m.dragging:
+		m.moveDrag(x, y)
+	}
+}
+
+func (m *Map) onPointerUp(event js.Value) {
+	id := event.Get("pointerId").Int()
+	if _, ok := m.pointers[id]; !ok {
+		return
+	}
+	delete(m.pointers, id)
+	x, y := m.pointerXY(event)
+	cancelled := event.Get("type").String() == "pointercancel"
+
+	switch {
+	case m.boxDown:
+		if len(m.pointers) == 0 {
+			m.endBox(x, y, cancelled)
+		}
+	case m.turning:
+		m.endTurn()
+	case m.pinching:
+		m.endPinch(cancelled)
+	case len(m.pointers) == 0:
+		m.endDrag(cancelled)
+	}
+
+	if len(m.pointers) == 0 {
+		m.tapping = false
+		m.endGesture()
+	}
+}
+
+// startDrag starts dragging the map from x and y on the canvas
+func (m *Map) startDrag(x, y float64) {
+	if !m.drag {
+		return
+	}
+	m.dragging = true
+	m.dragged = false
+	m.dragStartX, m.dragStartY = x, y
+	m.dragStartLat, m.dragStartLon = m.lat, m.lon
+	m.dragSamples = []dragSample{{t: now(), x: x, y: y}}
+}
+
+// moveDrag moves the map so the point under the start of the drag is under x
+// and y
+func (m *Map) moveDrag(x, y float64) {
+	m.sampleDrag(now(), x, y)
+
+	dx := m.dragStartX - x
+	dy := m.dragStartY - y
+	if math.Hypot(dx, dy) > clickTolerance {
+		m.dragged = true
+	}
+	dx, dy = rotate(dx, dy, m.bearing)
+
+	lat, lon := MoveBy(m.proj, m.zoom, m.dragStartLat, m.dragStartLon, dx, dy)
+	m.setPosition(m.zoom, lat, lon, m.maxBoundsViscosity)
+}
+
+// endDrag ends a drag or tap when the last pointer is lifted
+func (m *Map) endDrag(cancelled bool) {
+	t := now()
+	glide := m.dragging && m.inertia && !cancelled
+	tap := m.tapping && !cancelled && m.pointerType != "mouse" && t-m.tapStartTime < tapDuration
+	m.dragging = false
+
+	// Spring back inside the max bounds if a soft drag went past them
+	m.setPosition(m.zoom, m.lat, m.lon, 1)
+
+	if glide {
+		m.glide(t)
+	}
+
+	if !tap || !m.doubleClickZoom {
+		return
+	}
+	// Mice double click, touches and pens double tap
+	if t-m.lastTapTime < doubleTapInterval && math.Hypot(m.tapX-m.lastTapX, m.tapY-m.lastTapY) < doubleTapDistance {
+		m.lastTapTime = 0
+		m.animateZoomAround(math.Min(m.maxZoom, m.zoom+1), m.tapX, m.tapY, tapZoomDuration)
+		return
+	}
+	m.lastTapTime, m.lastTapX, m.lastTapY = t, m.tapX, m.tapY
+}
+
+// pinchPointers returns the two pointers of the pinch in the order they were
+// pressed, so the angle between them doesn't flip
+func (m *Map) pinchPointers() (p1, p2 pointer) {
+	return m.pointers[m.pinchIDs[0]], m.pointers[m.pinchIDs[1]]
+}
+
+// pinch returns the distance between the two pointers and the point between
+// them
+func (m *Map) pinch() (distance, x, y float64) {
+	p1, p2 := m.pinchPointers()
+	return math.Hypot(p1.x-p2.x, p1.y-p2.y), (p1.x + p2.x) / 2, (p1.y + p2.y) / 2
+}
+
+// startPinch starts zooming and turning with the two pointers that are down
+func (m *Map) startPinch() {
+	m.dragging = false
+	m.pinching = true
+	m.pinchZoomStart = m.zoom
+	m.pinchDistance, m.pinchX, m.pinchY = m.pinch()
+	m.pinchStartTime = now()
+	m.pinchMoved = false
+	m.twisting = false
+	m.pinchAngle, m.pinchBearing = m.pinchTwist(), m.bearing
+
+	// The point between the fingers stays between them
+	gx, gy := m.groundAnchor(m.pinchX, m.pinchY)
+	m.pinchLat, m.pinchLon = MoveBy(m.proj, m.zoom, m.lat, m.lon, gx, gy)
+}
+
+// movePinch zooms, turns and pans the map to follow the two pointers
+func (m *Map) movePinch() {
+	distance, x, y := m.pinch()
+	if m.pinchDistance == 0 || distance == 0 {
+		return
+	}
+
+	if math.Abs(distance-m.pinchDistance) > clickTolerance || math.Hypot(x-m.pinchX, y-m.pinchY) > clickTolerance {
+		m.pinchMoved = true
+	}
+
+	// Spreading the fingers twice as far apart zooms in one level, so the map
+	// stays under them
+	zoom := m.pinchZoomStart + math.Log2(distance/m.pinchDistance)
+	zoom = math.Max(m.minZoom, math.Min(m.maxZoom, zoom))
+
+	// Turn first so the point between the fingers is found on the turned map
+	if m.rotate {
+		m.twist()
+	}
+
+	gx, gy := m.groundAnchor(x, y)
+	lat, lon := AnchorCenter(m.proj, zoom, m.pinchLat, m.pinchLon, gx, gy)
+	m.setPosition(zoom, lat, lon, 1)
+}
+
+// endPinch ends a pinch when one of its pointers is lifted. A pinch that
+// hardly moved is a two finger tap, which zooms out.
+func (m *Map) endPinch(cancelled bool) {
+	m.pinching = false
+
+	if !cancelled && m.doubleClickZoom && !m.pinchMoved && now()-m.pinchStartTime < tapDuration {
+		m.animateZoomAround(math.Max(m.minZoom, m.zoom-1), m.pinchX, m.pinchY, tapZoomDuration)
+	}
+
+	// Carry on dragging with the pointer that's left
+	if len(m.pointers) == 1 {
+		for _, p := range m.pointers {
+			m.startDrag(p.x, p.y)
+		}
+		m.dragged = true
+	}
+}
+
+func (m *Map) onClick(event js.Value) {
+	if m.dragged {
+		m.dragged = false
+		return
+	}
+	m.firePointer(EventClick, event.Get("offsetX").Float(), event.Get("offsetY").Float())
+}
+
+func (m *Map) onDoubleClick(event js.Value) {
+	x, y := event.Get("offsetX").Float(), event.Get("offsetY").Float()
+	m.firePointer(EventDoubleClick, x, y)
+
+	// Double taps are zoomed by endDrag
+	if !m.doubleClickZoom || m.pointerType != "mouse" {
+		return
+	}
+	zoom := m.zoom + 1
+	if held(event, "shiftKey") {
+		zoom = m.zoom - 1
+	}
+	m.animateZoomAround(math.Max(m.minZoom, math.Min(m.maxZoom, zoom)), x, y, tapZoomDuration)
+}
+
+func (m *Map) onContextMenu(event js.Value) {
+	// It isn't a context menu once the map has been turned
+	if m.rotate && m.dragged {
+		m.dragged = false
+		return
+	}
+	m.firePointer(EventContextMenu, event.Get("offsetX").Float(), event.Get("offsetY").Float())
+}
+
+// startBox starts drawing a box to zoom to from x and y on the canvas
+func (m *Map) startBox(x, y float64) {
+	m.boxX, m.boxY = x, y
+	m.boxDown = true
+	m.dragged = false
+
+	if m.box == js.Undefined() {
+		m.box = js.Global().Get("document").Call("createElement", "div")
+		style := m.box.Get("style")
+		style.Set("position", "absolute")
+		style.Set("border", "2px dotted #38f")
+		style.Set("background", "rgba(255, 255, 255, 0.5)")
+		style.Set("boxSizing", "border-box")
+		style.Set("pointerEvents", "none")
+		m.positionContainer()
+		m.container.Call("appendChild", m.box)
+	}
+	m.drawBox(x, y)
+	m.box.Get("style").Set("display", "block")
+}
+
+// moveBox stretches the box to x and y
+func (m *Map) moveBox(x, y float64) {
+	if math.Hypot(x-m.boxX, y-m.boxY) > clickTolerance {
+		m.dragged = true
+	}
+	m.drawBox(x, y)
+}
+
+// drawBox draws the box from where it started to x and y on the canvas
+func (m *Map) drawBox(x, y float64) {
+	// The box is in the container, which the canvas might not fill
+	left := math.Min(x, m.boxX) + m.viewport.Get("offsetLeft").Float()
+	top := math.Min(y, m.boxY) + m.viewport.Get("offsetTop").Float()
+
+	style := m.box.Get("style")
+	style.Set("left", fmt.Sprintf("%vpx", left))
+	style.Set("top", fmt.Sprintf("%vpx", top))
+	style.Set("width", fmt.Sprintf("%vpx", math.Abs(x-m.boxX)))
+	style.Set("height", fmt.Sprintf("%vpx", math.Abs(y-m.boxY)))
+}
+
+// endBox hides the box and zooms the map to it, unless it's too small to be
+// anything but a click
+func (m *Map) endBox(x, y float64, cancelled bool) {
+	m.boxDown = false
+	m.box.Get("style").Set("display", "none")
+
+	if !m.dragged || cancelled {
+		return
+	}
+	bounds := m.rectBounds(math.Min(x, m.boxX), math.Min(y, m.boxY), math.Max(x, m.boxX), math.Max(y, m.boxY))
+	m.fitBounds(bounds, 0, m.maxZoom)
+}
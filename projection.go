@@ -0,0 +1,254 @@
+package pichiwmap
+
+import "math"
+
+// Projection converts between latitude/longitude and a projected coordinate
+// reference system, and describes the tile grid laid over it
+type Projection interface {
+	// Project converts latitude and longitude in degrees to projected x and y.
+	// y increases to the north.
+	Project(lat, lon float64) (x, y float64)
+	// Unproject converts projected x and y to latitude and longitude in degrees
+	Unproject(x, y float64) (lat, lon float64)
+	// Origin returns the top left corner of the tile grid in projected units
+	Origin() (x, y float64)
+	// Resolution returns the projected units covered by a pixel of a
+	// TileWidth x TileHeight tile at zoom
+	Resolution(zoom int) float64
+	// MatrixSize returns the number of tile columns and rows at zoom
+	MatrixSize(zoom int) (cols, rows int)
+}
+
+// The projections supported out of the box
+var (
+	EPSG3857 Projection = WebMercator{}
+	EPSG4326 Projection = PlateCarree{}
+)
+
+// WebMercator is the spherical mercator projection used by OpenStreetMap, Bing,
+// Google and most other slippy maps (EPSG:3857)
+type WebMercator struct{}
+
+// Project converts latitude and longitude in degrees to meters
+func (WebMercator) Project(lat, lon float64) (x, y float64) {
+	x = lon * DegToRad * EarthRadius
+	y = math.Log(math.Tan(math.Pi/4+lat*DegToRad/2)) * EarthRadius
+	return
+}
+
+// Unproject converts meters to latitude and longitude in degrees
+func (WebMercator) Unproject(x, y float64) (lat, lon float64) {
+	lon = x / EarthRadius * RadToDeg
+	lat = math.Atan(math.Sinh(y/EarthRadius)) * RadToDeg
+	return
+}
+
+// Origin returns the top left corner of the world in meters
+func (WebMercator) Origin() (x, y float64) {
+	return -math.Pi * EarthRadius, math.Pi * EarthRadius
+}
+
+// Resolution returns meters per pixel at the equator
+func (WebMercator) Resolution(zoom int) float64 {
+	return 2 * math.Pi * EarthRadius / TileWidth / zoomFactor(zoom)
+}
+
+// MatrixSize returns the number of tile columns and rows at zoom
+func (WebMercator) MatrixSize(zoom int) (cols, rows int) {
+	n := 1 << uint(zoom)
+	return n, n
+}
+
+// PlateCarree is the equirectangular projection of latitude and longitude
+// (EPSG:4326). Zoom 0 is two square tiles side by side covering the world.
+type PlateCarree struct{}
+
+// Project converts latitude and longitude to x and y, which are the same degrees
+func (PlateCarree) Project(lat, lon float64) (x, y float64) {
+	return lon, lat
+}
+
+// Unproject converts x and y to latitude and longitude
+func (PlateCarree) Unproject(x, y float64) (lat, lon float64) {
+	return y, x
+}
+
+// Origin returns the top left corner of the world in degrees
+func (PlateCarree) Origin() (x, y float64) {
+	return -180, 90
+}
+
+// Resolution returns degrees per pixel
+func (PlateCarree) Resolution(zoom int) float64 {
+	return 180.0 / TileWidth / zoomFactor(zoom)
+}
+
+// MatrixSize returns the number of tile columns and rows at zoom
+func (PlateCarree) MatrixSize(zoom int) (cols, rows int) {
+	n := 1 << uint(zoom)
+	return 2 * n, n
+}
+
+// TileXY returns the fractional tile x and y of latitude and longitude at zoom
+func TileXY(p Projection, zoom int, lat, lon float64) (x, y float64) {
+	px, py := p.Project(lat, lon)
+	ox, oy := p.Origin()
+	res := p.Resolution(zoom)
+	x = (px - ox) / res / TileWidth
+	y = (oy - py) / res / TileHeight
+	return
+}
+
+// TileLatLon returns the latitude and longitude of the fractional tile x and y at zoom
+func TileLatLon(p Projection, zoom int, x, y float64) (lat, lon float64) {
+	ox, oy := p.Origin()
+	res := p.Resolution(zoom)
+	return p.Unproject(ox+x*TileWidth*res, oy-y*TileHeight*res)
+}
+
+// WrapsX reports whether the tile grid spans the whole world from west to east,
+// so columns wrap around at the antimeridian
+func WrapsX(p Projection) bool {
+	cols, _ := p.MatrixSize(0)
+	_, west := TileLatLon(p, 0, 0, 0)
+	_, east := TileLatLon(p, 0, float64(cols), 0)
+	return math.Abs(east-west-360) < 1e-6
+}
+
+// LatRange returns the latitudes of the top and bottom of the tile grid. For
+// web mercator this is MaxLatitude north and south.
+func LatRange(p Projection) (north, south float64) {
+	_, rows := p.MatrixSize(0)
+	north, _ = TileLatLon(p, 0, 0, 0)
+	south, _ = TileLatLon(p, 0, 0, float64(rows))
+	return
+}
+
+// ClampLatLon keeps latitude inside the tile grid and, if the grid spans the
+// world, wraps longitude into [-180, 180)
+func ClampLatLon(p Projection, lat, lon float64) (float64, float64) {
+	north, south := LatRange(p)
+	lat = math.Max(south, math.Min(north, lat))
+	if WrapsX(p) {
+		lon = NormalizeLon(lon)
+	}
+	return lat, lon
+}
+
+// WrapCol wraps the column x into the tile grid at zoom. ok is false if the
+// column is outside a grid that doesn't wrap.
+func WrapCol(p Projection, zoom, x int) (col int, ok bool) {
+	cols, _ := p.MatrixSize(zoom)
+	if cols <= 0 {
+		return x, false
+	}
+	if WrapsX(p) {
+		return ((x % cols) + cols) % cols, true
+	}
+	return x, x >= 0 && x < cols
+}
+
+// ZoomResolution returns the projected units covered by a pixel at the
+// fractional zoom, interpolating between the resolutions of the zoom levels
+// either side of it
+func ZoomResolution(p Projection, zoom float64) float64 {
+	izoom := int(math.Floor(zoom))
+	res := p.Resolution(izoom)
+	frac := zoom - float64(izoom)
+	if frac == 0 {
+		return res
+	}
+	return res * math.Pow(p.Resolution(izoom+1)/res, frac)
+}
+
+// ResolutionZoom is the inverse of ZoomResolution, returning the fractional
+// zoom where a pixel covers res projected units. Past either end of the zoom
+// levels it carries on at the rate of the nearest two.
+func ResolutionZoom(p Projection, res float64) float64 {
+	zoom := 0
+	for zoom < MaxZoomLevel && p.Resolution(zoom+1) >= res {
+		zoom++
+	}
+	r0, r1 := p.Resolution(zoom), p.Resolution(zoom+1)
+	return float64(zoom) + math.Log(res/r0)/math.Log(r1/r0)
+}
+
+// ZoomScale returns how much tiles from tileZoom have to be scaled by to be
+// drawn at the fractional zoom
+func ZoomScale(p Projection, zoom float64, tileZoom int) float64 {
+	return p.Resolution(tileZoom) / ZoomResolution(p, zoom)
+}
+
+// WorldXY returns the position of latitude and longitude in pixels from the
+// top left of the tile grid at the fractional zoom
+func WorldXY(p Projection, zoom, lat, lon float64) (x, y float64) {
+	px, py := p.Project(lat, lon)
+	ox, oy := p.Origin()
+	res := ZoomResolution(p, zoom)
+	return (px - ox) / res, (oy - py) / res
+}
+
+// WorldLatLon returns the latitude and longitude of the pixel x and y from the
+// top left of the tile grid at the fractional zoom
+func WorldLatLon(p Projection, zoom, x, y float64) (lat, lon float64) {
+	ox, oy := p.Origin()
+	res := ZoomResolution(p, zoom)
+	return p.Unproject(ox+x*res, oy-y*res)
+}
+
+// MoveBy moves latitude and longitude by the delta pixels dx and dy at zoom
+func MoveBy(p Projection, zoom, lat, lon, dx, dy float64) (nlat, nlon float64) {
+	x, y := WorldXY(p, zoom, lat, lon)
+	return WorldLatLon(p, zoom, x+dx, y+dy)
+}
+
+// AnchorCenter returns the center that puts latitude and longitude gx and gy
+// pixels from it at zoom
+func AnchorCenter(p Projection, zoom, lat, lon, gx, gy float64) (clat, clon float64) {
+	x, y := WorldXY(p, zoom, lat, lon)
+	return WorldLatLon(p, zoom, x-gx, y-gy)
+}
+
+// ZoomAround returns the center to zoom to newZoom from zoom at lat and lon
+// while the point gx and gy pixels from the center stays where it is, such as
+// the point under the mouse
+func ZoomAround(p Projection, zoom, lat, lon, newZoom, gx, gy float64) (nlat, nlon float64) {
+	alat, alon := MoveBy(p, zoom, lat, lon, gx, gy)
+	return AnchorCenter(p, newZoom, alat, alon, gx, gy)
+}
+
+// TileBounds returns the extent of the tile at zoom, x, and y in projected units
+func TileBounds(p Projection, zoom, x, y int) (minX, minY, maxX, maxY float64) {
+	ox, oy := p.Origin()
+	res := p.Resolution(zoom)
+	minX = ox + float64(x)*TileWidth*res
+	maxX = minX + TileWidth*res
+	maxY = oy - float64(y)*TileHeight*res
+	minY = maxY - TileHeight*res
+	return
+}
+
+// TileRange returns the columns and rows of the tiles at zoom that cover the
+// extent in projected units. The range isn't limited to the tile grid.
+func TileRange(p Projection, zoom int, minX, minY, maxX, maxY float64) (minCol, minRow, maxCol, maxRow int) {
+	ox, oy := p.Origin()
+	res := p.Resolution(zoom)
+	minCol = int(math.Floor((minX - ox) / res / TileWidth))
+	maxCol = int(math.Floor((maxX - ox) / res / TileWidth))
+	minRow = int(math.Floor((oy - maxY) / res / TileHeight))
+	maxRow = int(math.Floor((oy - minY) / res / TileHeight))
+	return
+}
+
+// ViewTileRange returns the columns and rows of the tiles at tileZoom needed to
+// fill a width x height pixel view at the fractional zoom centered on latitude
+// and longitude, with a tile of margin on each side
+func ViewTileRange(p Projection, tileZoom int, zoom, lat, lon float64, width, height int) (minCol, minRow, maxCol, maxRow int) {
+	cx, cy := p.Project(lat, lon)
+	res := ZoomResolution(p, zoom)
+	hw := float64(width) / 2 * res
+	hh := float64(height) / 2 * res
+
+	minCol, minRow, maxCol, maxRow = TileRange(p, tileZoom, cx-hw, cy-hh, cx+hw, cy+hh)
+	return minCol - 1, minRow - 1, maxCol + 1, maxRow + 1
+}
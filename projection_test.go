@@ -0,0 +1,193 @@
+package pichiwmap
+
+import (
+	"math"
+	"testing"
+)
+
+func TestProjectRoundTrip(t *testing.T) {
+	points := [][2]float64{{0, 0}, {45, 90}, {-45, -90}, {85, 179.9}, {-85, -179.9}, {51.5, -0.12}, {-33.9, 151.2}}
+
+	for _, p := range []Projection{EPSG3857, EPSG4326} {
+		for _, pt := range points {
+			x, y := p.Project(pt[0], pt[1])
+			lat, lon := p.Unproject(x, y)
+			if math.Abs(lat-pt[0]) > 1e-9 || math.Abs(lon-pt[1]) > 1e-9 {
+				t.Errorf("%T Unproject(Project(%v, %v)) = %v, %v", p, pt[0], pt[1], lat, lon)
+			}
+		}
+	}
+}
+
+func TestProjectKnownValues(t *testing.T) {
+	edge := math.Pi * EarthRadius
+
+	tests := []struct {
+		p        Projection
+		lat, lon float64
+		x, y     float64
+	}{
+		{EPSG3857, 0, 0, 0, 0},
+		{EPSG3857, 0, 180, edge, 0},
+		{EPSG3857, 0, -90, -edge / 2, 0},
+		// The world is square, so the top is as far north as the antimeridian
+		// is east
+		{EPSG3857, MaxLatitude, 0, 0, edge},
+		{EPSG4326, 10, 20, 20, 10},
+		{EPSG4326, -90, -180, -180, -90},
+	}
+
+	for _, tt := range tests {
+		x, y := tt.p.Project(tt.lat, tt.lon)
+		if math.Abs(x-tt.x) > 1e-6 || math.Abs(y-tt.y) > 1e-6 {
+			t.Errorf("%T Project(%v, %v) = %v, %v, want %v, %v", tt.p, tt.lat, tt.lon, x, y, tt.x, tt.y)
+		}
+	}
+}
+
+func TestZoomResolution(t *testing.T) {
+	for _, p := range []Projection{EPSG3857, EPSG4326} {
+		for zoom := 0; zoom <= MaxZoomLevel; zoom++ {
+			if got, want := ZoomResolution(p, float64(zoom)), p.Resolution(zoom); got != want {
+				t.Errorf("%T ZoomResolution(%v) = %v, want %v", p, zoom, got, want)
+			}
+		}
+
+		// Halfway between zoom levels is the geometric mean
+		got := ZoomResolution(p, 3.5)
+		want := math.Sqrt(p.Resolution(3) * p.Resolution(4))
+		if math.Abs(got-want) > want*1e-12 {
+			t.Errorf("%T ZoomResolution(3.5) = %v, want %v", p, got, want)
+		}
+	}
+
+	// A 256 pixel tile covers the world at zoom 0
+	if got, want := EPSG3857.Resolution(0)*TileWidth, 2*math.Pi*EarthRadius; math.Abs(got-want) > 1e-6 {
+		t.Errorf("EPSG3857 world width %v, want %v", got, want)
+	}
+	if got := EPSG4326.Resolution(0) * TileWidth; got != 180 {
+		t.Errorf("EPSG4326 tile width %v degrees, want 180", got)
+	}
+}
+
+func TestResolutionZoom(t *testing.T) {
+	for _, p := range []Projection{EPSG3857, EPSG4326} {
+		for _, zoom := range []float64{0, 0.25, 1, 3.5, 7.9, 12, 20.5, MaxZoomLevel - 1} {
+			res := ZoomResolution(p, zoom)
+			if got := ResolutionZoom(p, res); math.Abs(got-zoom) > 1e-9 {
+				t.Errorf("%T ResolutionZoom(ZoomResolution(%v)) = %v", p, zoom, got)
+			}
+		}
+
+		// Coarser than zoom 0 extrapolates to a negative zoom
+		if got := ResolutionZoom(p, p.Resolution(0)*2); math.Abs(got+1) > 1e-9 {
+			t.Errorf("%T ResolutionZoom(2 * Resolution(0)) = %v, want -1", p, got)
+		}
+	}
+}
+
+func TestWorldXY(t *testing.T) {
+	tests := []struct {
+		p        Projection
+		zoom     float64
+		lat, lon float64
+		x, y     float64
+	}{
+		{EPSG3857, 0, 0, 0, 128, 128},
+		{EPSG3857, 1, 0, 0, 256, 256},
+		{EPSG3857, 2, MaxLatitude, -180, 0, 0},
+		{EPSG4326, 0, 0, 0, 256, 128},
+		{EPSG4326, 1, 90, -180, 0, 0},
+		{EPSG4326, 1, -90, 180, 1024, 512},
+	}
+
+	for _, tt := range tests {
+		x, y := WorldXY(tt.p, tt.zoom, tt.lat, tt.lon)
+		if math.Abs(x-tt.x) > 1e-6 || math.Abs(y-tt.y) > 1e-6 {
+			t.Errorf("%T WorldXY(%v, %v, %v) = %v, %v, want %v, %v", tt.p, tt.zoom, tt.lat, tt.lon, x, y, tt.x, tt.y)
+		}
+	}
+}
+
+func TestWorldXYRoundTrip(t *testing.T) {
+	points := [][2]float64{{0, 0}, {60, -120}, {-60, 45.5}, {84, 179}}
+
+	for _, p := range []Projection{EPSG3857, EPSG4326} {
+		for _, zoom := range []float64{0, 1.25, 7, 12.5, 18} {
+			for _, pt := range points {
+				x, y := WorldXY(p, zoom, pt[0], pt[1])
+				lat, lon := WorldLatLon(p, zoom, x, y)
+				if math.Abs(lat-pt[0]) > 1e-9 || math.Abs(lon-pt[1]) > 1e-9 {
+					t.Errorf("%T zoom %v WorldLatLon(WorldXY(%v, %v)) = %v, %v", p, zoom, pt[0], pt[1], lat, lon)
+				}
+			}
+		}
+	}
+}
+
+// regional is a plate carrée grid covering only the western hemisphere, so its
+// columns don't wrap
+type regional struct{ PlateCarree }
+
+func (regional) MatrixSize(zoom int) (cols, rows int) {
+	return 1 << uint(zoom), 1 << uint(zoom)
+}
+
+func TestWrapCol(t *testing.T) {
+	tests := []struct {
+		p       Projection
+		zoom, x int
+		col     int
+		ok      bool
+	}{
+		{EPSG3857, 0, 0, 0, true},
+		{EPSG3857, 0, -1, 0, true},
+		{EPSG3857, 2, 4, 0, true},
+		{EPSG3857, 2, -1, 3, true},
+		{EPSG3857, 2, -4, 0, true},
+		{EPSG3857, 2, -5, 3, true},
+		{EPSG3857, 2, 9, 1, true},
+		{EPSG4326, 0, -1, 1, true},
+		{EPSG4326, 1, 4, 0, true},
+		{EPSG4326, 1, -3, 1, true},
+		{regional{}, 1, 1, 1, true},
+		{regional{}, 1, 2, 2, false},
+		{regional{}, 1, -1, -1, false},
+	}
+
+	for _, tt := range tests {
+		col, ok := WrapCol(tt.p, tt.zoom, tt.x)
+		if col != tt.col || ok != tt.ok {
+			t.Errorf("%T WrapCol(%v, %v) = %v, %v, want %v, %v", tt.p, tt.zoom, tt.x, col, ok, tt.col, tt.ok)
+		}
+	}
+}
+
+func TestClampLatLon(t *testing.T) {
+	tests := []struct {
+		p                Projection
+		lat, lon         float64
+		wantLat, wantLon float64
+	}{
+		{EPSG3857, 10, 20, 10, 20},
+		{EPSG3857, 89, 0, MaxLatitude, 0},
+		{EPSG3857, -90, 0, -MaxLatitude, 0},
+		{EPSG3857, MaxLatitude, 180, MaxLatitude, -180},
+		{EPSG3857, 0, -181, 0, 179},
+		{EPSG3857, 0, 540, 0, -180},
+		{EPSG4326, 95, 190, 90, -170},
+		{EPSG4326, -95, -180, -90, -180},
+		{regional{}, 0, 190, 0, 190},
+	}
+
+	for _, tt := range tests {
+		lat, lon := ClampLatLon(tt.p, tt.lat, tt.lon)
+		if math.Abs(lat-tt.wantLat) > 1e-9 || math.Abs(lon-tt.wantLon) > 1e-9 {
+			t.Errorf("%T ClampLatLon(%v, %v) = %v, %v, want %v, %v", tt.p, tt.lat, tt.lon, lat, lon, tt.wantLat, tt.wantLon)
+		}
+	}
+
+	if north, south := LatRange(EPSG3857); math.Abs(north-MaxLatitude) > 1e-9 || math.Abs(south+MaxLatitude) > 1e-9 {
+		t.Errorf("EPSG3857 LatRange() = %v, %v, want ±%v", north, south, MaxLatitude)
+	}
+}
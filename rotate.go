@@ -0,0 +1,134 @@
+package pichiwmap
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gowasm/gopherwasm/js"
+)
+
+// Rotating follows the mouse or fingers, with some slack so a pinch doesn't
+// turn the map by accident
+const (
+	// turnDegreesPerPixel is how far the map turns for each CSS pixel the mouse
+	// is dragged across with the right button or ctrl held
+	turnDegreesPerPixel = 0.8
+	// twistThreshold is how many degrees two fingers have to turn before the
+	// map turns with them
+	twistThreshold = 10
+	// resetNorthDuration is how long turning back to north takes
+	resetNorthDuration = 300 * time.Millisecond
+)
+
+// RotateTo animates the map to bearing, in degrees clockwise from north, over
+// duration. It turns the short way round. Any user input or new position
+// stops it.
+func (m *Map) RotateTo(bearing float64, duration time.Duration) {
+	m.mu.Lock()
+	defer m.unlock()
+
+	m.rotateTo(bearing, duration)
+}
+
+func (m *Map) rotateTo(bearing float64, duration time.Duration) {
+	m.easeTo(m.zoom, m.lat, m.lon, bearing, duration, EaseInOut)
+}
+
+// startTurn starts turning the map with a mouse drag from x on the canvas
+func (m *Map) startTurn(x float64) {
+	m.turning = true
+	m.dragged = false
+	m.turnStartX = x
+	m.turnStartBearing = m.bearing
+}
+
+// moveTurn turns the map by how far the mouse has moved across since the
+// turn started
+func (m *Map) moveTurn(x float64) {
+	if math.Abs(x-m.turnStartX) > clickTolerance {
+		m.dragged = true
+	}
+	m.setBearing(m.turnStartBearing + (x-m.turnStartX)*turnDegreesPerPixel)
+}
+
+func (m *Map) endTurn() {
+	m.turning = false
+}
+
+// pinchTwist returns the angle of the line between the two pointers, in
+// degrees clockwise on the canvas
+func (m *Map) pinchTwist() float64 {
+	p1, p2 := m.pinchPointers()
+	return math.Atan2(p2.y-p1.y, p2.x-p1.x) * 180 / math.Pi
+}
+
+// twist turns the map with the two pointers once they've turned past
+// twistThreshold
+func (m *Map) twist() {
+	angle := m.pinchTwist()
+	turned := NormalizeLon(angle - m.pinchAngle)
+
+	if !m.twisting {
+		if math.Abs(turned) < twistThreshold {
+			return
+		}
+		// Start from here so the map doesn't jump by the threshold
+		m.twisting = true
+		m.pinchMoved = true
+		m.pinchAngle, m.pinchBearing = angle, m.bearing
+		return
+	}
+
+	// Turning the fingers clockwise turns the map clockwise, so the bearing
+	// at the top goes back
+	m.setBearing(m.pinchBearing - turned)
+}
+
+// drawNorthControl points the reset north control's arrow north, hiding it
+// while the map faces north
+func (m *Map) drawNorthControl() {
+	if m.northControl == js.Undefined() {
+		return
+	}
+	style := m.northControl.Get("style")
+	if m.bearing == 0 {
+		style.Set("display", "none")
+		return
+	}
+	style.Set("display", "block")
+	m.northControl.Get("firstChild").Get("style").Set("transform", fmt.Sprintf("rotate(%vdeg)", -m.bearing))
+}
+
+// newNorthControl creates the button that turns the map back to north, hidden
+func newNorthControl(doc js.Value) js.Value {
+	button := doc.Call("createElement", "button")
+	button.Set("type", "button")
+	button.Set("title", "Reset north")
+	button.Call("setAttribute", "aria-label", "Reset north")
+
+	style := button.Get("style")
+	style.Set("position", "absolute")
+	style.Set("top", "10px")
+	style.Set("right", "10px")
+	style.Set("width", "30px")
+	style.Set("height", "30px")
+	style.Set("padding", "0")
+	style.Set("border", "none")
+	style.Set("borderRadius", "4px")
+	style.Set("background", "white")
+	style.Set("boxShadow", "0 0 0 2px rgba(0, 0, 0, 0.1)")
+	style.Set("cursor", "pointer")
+	style.Set("display", "none")
+
+	arrow := doc.Call("createElement", "span")
+	arrow.Set("textContent", "▲")
+
+	style = arrow.Get("style")
+	style.Set("display", "block")
+	style.Set("color", "#e33")
+	style.Set("fontSize", "16px")
+	style.Set("lineHeight", "30px")
+	button.Call("appendChild", arrow)
+	return button
+}
@@ -0,0 +1,23 @@
+package pichiwmap
+
+import "testing"
+
+func TestPinchTwistOrder(t *testing.T) {
+	m, _ := newTestMap()
+
+	// More pointers than the pinch uses, so ranging over them would come out in
+	// different orders
+	m.pointers = map[int]pointer{
+		1: {x: 100, y: 100},
+		2: {x: 200, y: 200},
+		3: {x: 300, y: 100},
+		4: {x: 50, y: 50},
+	}
+	m.pinchIDs = [2]int{2, 1}
+
+	for i := 0; i < 100; i++ {
+		if angle := m.pinchTwist(); angle != -135 {
+			t.Fatalf("pinchTwist() = %v on call %v, want -135", angle, i)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package pichiwmap
 
 import (
+	"errors"
+	"fmt"
 	"math"
 	"net/url"
+	"strings"
 )
 
 // Converts for degrees and radians
@@ -11,12 +14,67 @@ const (
 	DegToRad = math.Pi / 180
 )
 
-var zooms = map[int]float64{}
+// MaxZoomLevel is the deepest the map can zoom. Past this the precision of
+// latitude and longitude starts to show.
+const MaxZoomLevel = 24
 
-func init() {
-	for i := 0; i <= 18; i++ {
-		zooms[i] = math.Pow(2, float64(i))
+// zoomFactor returns 2^zoom, the number of tiles across the world at zoom
+func zoomFactor(zoom int) float64 {
+	return math.Ldexp(1, zoom)
+}
+
+// MaxLatitude is the furthest north or south web mercator reaches, which makes
+// the world square
+const MaxLatitude = 85.0511287798066
+
+// NormalizeLon wraps a longitude into [-180, 180)
+func NormalizeLon(lon float64) float64 {
+	lon = math.Mod(lon+180, 360)
+	if lon < 0 {
+		lon += 360
+	}
+	return lon - 180
+}
+
+// Bounds is a box of latitude and longitude. When it crosses the antimeridian
+// West is greater than East.
+type Bounds struct {
+	North float64
+	South float64
+	East  float64
+	West  float64
+}
+
+// WorldBounds covers all of web mercator
+var WorldBounds = Bounds{North: MaxLatitude, South: -MaxLatitude, East: 180, West: -180}
+
+// CrossesAntimeridian reports whether the bounds wrap from 180 to -180
+func (b Bounds) CrossesAntimeridian() bool {
+	return b.West > b.East
+}
+
+// UnwrappedEast returns East, plus 360 if the bounds cross the antimeridian, so
+// it is always east of West
+func (b Bounds) UnwrappedEast() float64 {
+	if b.CrossesAntimeridian() {
+		return b.East + 360
+	}
+	return b.East
+}
+
+// Contains reports whether latitude and longitude are inside the bounds
+func (b Bounds) Contains(lat, lon float64) bool {
+	if lat > b.North || lat < b.South {
+		return false
 	}
+	if b.East-b.West >= 360 {
+		return true
+	}
+	lon = NormalizeLon(lon)
+	if b.CrossesAntimeridian() {
+		return lon >= b.West || lon <= b.East
+	}
+	return lon >= b.West && lon <= b.East
 }
 
 // Tile widths and heights
@@ -27,14 +85,34 @@ const (
 
 // Tile represents a tile to be rendered
 type Tile struct {
-	// Lat is the delta of X from the center of the canvas
+	// Lat is the latitude of the northwest corner of the tile
 	Lat float64
-	// Lon is the delta of Y from the center of the canvas
+	// Lon is the longitude of the northwest corner of the tile
 	Lon float64
+	// X is the column of the tile in the tile grid
+	X int
+	// Y is the row of the tile in the tile grid
+	Y int
 	// The URL where we can load the tile
 	URL *url.URL
 	// The zoom level of the tile
 	Zoom int
+	// Size is the width and height of the tile in pixels when it's drawn at its
+	// own zoom level
+	Size int
+	// TexX, TexY and TexSize are the part of the image at URL that covers the
+	// tile, as fractions of the image. They are 0, 0 and 1 unless the tile is
+	// cut out of a tile from a shallower zoom because it is past the source's
+	// MaxNativeZoom.
+	TexX    float64
+	TexY    float64
+	TexSize float64
+}
+
+// Key identifies the tile. Copies of a tile in different worlds share a URL but
+// not a key.
+func (t *Tile) Key() string {
+	return fmt.Sprintf("%v/%v/%v", t.Zoom, t.X, t.Y)
 }
 
 // URLer is anything that can generate a URL from a zoom, x, and y value
@@ -42,37 +120,150 @@ type URLer interface {
 	URL(zoom, x, y int) *url.URL
 }
 
-// TileNum returns the tile x and y and pixel offset from the zoom, lat, and lon
+// NativeZoomer is implemented by URLers that only have tiles up to a zoom
+// level. When the map is zoomed in further the tiles from that level are scaled
+// up instead.
+type NativeZoomer interface {
+	MaxNativeZoom() int
+}
+
+// TileSizer is implemented by URLers whose tiles aren't TileWidth x TileHeight.
+// A tile covers the same ground whatever its size, so bigger tiles are taken
+// from shallower zoom levels.
+type TileSizer interface {
+	TileSize() int
+}
+
+// Tile sizes a source can have. Sizes in between must be powers of two.
+const (
+	MinTileSize = 64
+	MaxTileSize = 1024
+)
+
+// ErrInvalidTileSize is returned for tile sizes ValidTileSize rejects
+var ErrInvalidTileSize = errors.New("tile size must be a power of two from 64 to 1024")
+
+// ValidTileSize reports whether size is a power of two from MinTileSize to
+// MaxTileSize
+func ValidTileSize(size int) bool {
+	return size >= MinTileSize && size <= MaxTileSize && size&(size-1) == 0
+}
+
+// NewSource wraps a URLer whose deepest tiles are at maxNativeZoom and whose
+// tiles are tileSize pixels square (512 for most vector-derived raster tiles)
+func NewSource(urlEr URLer, maxNativeZoom, tileSize int) (*Source, error) {
+	if !ValidTileSize(tileSize) {
+		return nil, ErrInvalidTileSize
+	}
+	return &Source{urlEr: urlEr, maxNativeZoom: maxNativeZoom, tileSize: tileSize}, nil
+}
+
+// Source is a URLer with details of the tiles it serves
+type Source struct {
+	urlEr         URLer
+	maxNativeZoom int
+	tileSize      int
+}
+
+// URL calculates a URL from zoom, x, and y
+func (s *Source) URL(zoom, x, y int) *url.URL {
+	return s.urlEr.URL(zoom, x, y)
+}
+
+// MaxNativeZoom returns the deepest zoom level the source has tiles for
+func (s *Source) MaxNativeZoom() int {
+	return s.maxNativeZoom
+}
+
+// TileSize returns the width and height of the source's tiles in pixels
+func (s *Source) TileSize() int {
+	return s.tileSize
+}
+
+// TileZoom returns the zoom level of tiles tileSize pixels square that are
+// drawn at the fractional zoom
+func TileZoom(zoom float64, tileSize int) int {
+	z := int(math.Floor(zoom)) - int(math.Round(math.Log2(float64(tileSize)/TileWidth)))
+	if z < 0 {
+		return 0
+	}
+	return z
+}
+
+// TileNum returns the web mercator tile x and y and pixel offset from the zoom, lat, and lon
 func TileNum(zoom int, lat, lon float64) (x, y float64) {
-	latRad := lat * DegToRad
-	n := zooms[zoom]
-	x = (lon + 180.0) / 360.0 * n
-	y = (1.0 - math.Log(math.Tan(latRad)+(1/math.Cos(latRad)))/math.Pi) / 2.0 * n
-	return
+	return TileXY(EPSG3857, zoom, lat, lon)
 }
 
-// Move moves the lat and long by the delta pixels pdx and pdy
+// Move moves the lat and long by the delta pixels pdx and pdy in web mercator
 func Move(zoom, lat, lon float64, pdx int, pdy int) (nlat, nlon float64) {
-	xf, yf := TileNum(int(zoom), lat, lon)
-	dx := float64(pdx) / TileWidth
-	dy := float64(pdy) / TileHeight
+	return MoveBy(EPSG3857, zoom, lat, lon, float64(pdx), float64(pdy))
+}
 
-	return latlonFromXY(int(zoom), xf+(dx), yf+(dy))
+// NW returns the northwest corner of the web mercator tile in lat/lon degrees
+func NW(zoom, x, y int) (lat, lon float64) {
+	return TileLatLon(EPSG3857, zoom, float64(x), float64(y))
+}
+
+// ErrInvalidQuadkey is returned when a quadkey contains anything other than 0-3
+var ErrInvalidQuadkey = errors.New("invalid quadkey")
+
+// Quadkey returns the Bing quadkey for the tile at zoom, x, and y
+// https://docs.microsoft.com/en-us/bingmaps/articles/bing-maps-tile-system
+func Quadkey(zoom, x, y int) string {
+	var sb strings.Builder
+	for i := zoom; i > 0; i-- {
+		digit := byte('0')
+		mask := 1 << uint(i-1)
+		if x&mask != 0 {
+			digit++
+		}
+		if y&mask != 0 {
+			digit += 2
+		}
+		sb.WriteByte(digit)
+	}
+	return sb.String()
 }
 
-func latlonFromXY(zoom int, x, y float64) (lat, lon float64) {
-	n := zooms[zoom]
-	lon = x/n*360.0 - 180.0
-	latRad := math.Atan(math.Sinh(math.Pi * (1 - 2*y/n)))
-	lat = latRad * RadToDeg
+// QuadkeyTile returns the zoom, x, and y of the tile with the Bing quadkey
+func QuadkeyTile(quadkey string) (zoom, x, y int, err error) {
+	zoom = len(quadkey)
+	for i := zoom; i > 0; i-- {
+		mask := 1 << uint(i-1)
+		switch quadkey[zoom-i] {
+		case '0':
+		case '1':
+			x |= mask
+		case '2':
+			y |= mask
+		case '3':
+			x |= mask
+			y |= mask
+		default:
+			return 0, 0, 0, ErrInvalidQuadkey
+		}
+	}
 	return
 }
 
-// NW returns the northwest corner of the tile in lat/lon degrees
-func NW(zoom, x, y int) (lat, lon float64) {
-	n := zooms[zoom]
-	lon = float64(x)/n*360.0 - 180.0
-	latRad := math.Atan(math.Sinh(math.Pi * (1 - 2*float64(y)/n)))
-	lat = latRad * RadToDeg
+// TMSY converts between XYZ and TMS y values at zoom. TMS counts rows from the
+// south so the conversion is the same in both directions.
+func TMSY(zoom, y int) int {
+	return (1 << uint(zoom)) - 1 - y
+}
+
+// EarthRadius is the radius in meters used by spherical (web) mercator
+const EarthRadius = 6378137
+
+// MercatorBounds returns the bounds of the tile in EPSG:3857 meters
+func MercatorBounds(zoom, x, y int) (minX, minY, maxX, maxY float64) {
+	size := 2 * math.Pi * EarthRadius / zoomFactor(zoom)
+	origin := math.Pi * EarthRadius
+
+	minX = float64(x)*size - origin
+	maxX = minX + size
+	maxY = origin - float64(y)*size
+	minY = maxY - size
 	return
 }
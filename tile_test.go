@@ -0,0 +1,187 @@
+package pichiwmap
+
+import (
+	"math"
+	"testing"
+)
+
+func TestQuadkey(t *testing.T) {
+	// The example from the Bing Maps tile system article
+	if got := Quadkey(3, 3, 5); got != "213" {
+		t.Errorf("Quadkey(3, 3, 5) = %q, want \"213\"", got)
+	}
+	if got := Quadkey(0, 0, 0); got != "" {
+		t.Errorf("Quadkey(0, 0, 0) = %q, want \"\"", got)
+	}
+}
+
+func TestQuadkeyRoundTrip(t *testing.T) {
+	for zoom := 0; zoom <= 24; zoom++ {
+		max := 1<<uint(zoom) - 1
+		tiles := [][2]int{{0, 0}, {max, 0}, {0, max}, {max, max}, {max / 2, max / 3}, {max / 3, max / 2}}
+
+		for _, tile := range tiles {
+			x, y := tile[0], tile[1]
+			q := Quadkey(zoom, x, y)
+			if len(q) != zoom {
+				t.Errorf("Quadkey(%v, %v, %v) = %q, want %v digits", zoom, x, y, q, zoom)
+			}
+
+			gotZoom, gotX, gotY, err := QuadkeyTile(q)
+			if err != nil {
+				t.Errorf("QuadkeyTile(%q): %v", q, err)
+				continue
+			}
+			if gotZoom != zoom || gotX != x || gotY != y {
+				t.Errorf("QuadkeyTile(%q) = %v, %v, %v, want %v, %v, %v", q, gotZoom, gotX, gotY, zoom, x, y)
+			}
+		}
+	}
+}
+
+func TestQuadkeyTileInvalid(t *testing.T) {
+	for _, q := range []string{"4", "0124", "01a", "-1"} {
+		if _, _, _, err := QuadkeyTile(q); err != ErrInvalidQuadkey {
+			t.Errorf("QuadkeyTile(%q) = %v, want %v", q, err, ErrInvalidQuadkey)
+		}
+	}
+}
+
+func TestTMSY(t *testing.T) {
+	tests := []struct {
+		zoom, y, want int
+	}{
+		{0, 0, 0},
+		{1, 0, 1},
+		{1, 1, 0},
+		{3, 1, 6},
+		{18, 0, 1<<18 - 1},
+	}
+
+	for _, tt := range tests {
+		if got := TMSY(tt.zoom, tt.y); got != tt.want {
+			t.Errorf("TMSY(%v, %v) = %v, want %v", tt.zoom, tt.y, got, tt.want)
+		}
+		// The flip is its own inverse
+		if got := TMSY(tt.zoom, TMSY(tt.zoom, tt.y)); got != tt.y {
+			t.Errorf("TMSY(%v, TMSY(%v, %v)) = %v, want %v", tt.zoom, tt.zoom, tt.y, got, tt.y)
+		}
+	}
+}
+
+func TestNewSource(t *testing.T) {
+	urlEr, err := NewTemplateURLer("https://tile.example.com/{z}/{x}/{y}.png", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int{64, 128, 256, 512, 1024} {
+		s, err := NewSource(urlEr, 14, size)
+		if err != nil {
+			t.Errorf("NewSource(%v): %v", size, err)
+			continue
+		}
+		if s.TileSize() != size || s.MaxNativeZoom() != 14 {
+			t.Errorf("NewSource(%v) = size %v max native zoom %v", size, s.TileSize(), s.MaxNativeZoom())
+		}
+	}
+
+	for _, size := range []int{-256, 0, 1, 2, 32, 300, 2048} {
+		if _, err := NewSource(urlEr, 14, size); err != ErrInvalidTileSize {
+			t.Errorf("NewSource(%v) = %v, want %v", size, err, ErrInvalidTileSize)
+		}
+	}
+}
+
+func TestTileZoom(t *testing.T) {
+	tests := []struct {
+		zoom     float64
+		tileSize int
+		want     int
+	}{
+		{0, 256, 0},
+		{3.7, 256, 3},
+		{3.7, 512, 2},
+		{3.7, 1024, 1},
+		{3.7, 128, 4},
+		{3.7, 64, 5},
+		{0.5, 512, 0},
+	}
+
+	for _, tt := range tests {
+		if got := TileZoom(tt.zoom, tt.tileSize); got != tt.want {
+			t.Errorf("TileZoom(%v, %v) = %v, want %v", tt.zoom, tt.tileSize, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeLon(t *testing.T) {
+	tests := []struct{ lon, want float64 }{
+		{0, 0},
+		{179.5, 179.5},
+		{180, -180},
+		{-180, -180},
+		{-180.5, 179.5},
+		{181, -179},
+		{360, 0},
+		{-360, 0},
+		{540, -180},
+		{725, 5},
+		{-725, -5},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeLon(tt.lon); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("NormalizeLon(%v) = %v, want %v", tt.lon, got, tt.want)
+		}
+	}
+}
+
+func TestBounds(t *testing.T) {
+	europe := Bounds{North: 60, South: 35, East: 30, West: -10}
+	pacific := Bounds{North: 10, South: -10, East: -170, West: 170}
+
+	if europe.CrossesAntimeridian() {
+		t.Errorf("%v CrossesAntimeridian() = true, want false", europe)
+	}
+	if !pacific.CrossesAntimeridian() {
+		t.Errorf("%v CrossesAntimeridian() = false, want true", pacific)
+	}
+	if got := europe.UnwrappedEast(); got != 30 {
+		t.Errorf("%v UnwrappedEast() = %v, want 30", europe, got)
+	}
+	if got := pacific.UnwrappedEast(); got != 190 {
+		t.Errorf("%v UnwrappedEast() = %v, want 190", pacific, got)
+	}
+
+	tests := []struct {
+		b        Bounds
+		lat, lon float64
+		want     bool
+	}{
+		{europe, 50, 0, true},
+		{europe, 60, 30, true},
+		{europe, 35, -10, true},
+		{europe, 61, 0, false},
+		{europe, 50, 31, false},
+		{europe, 50, 360, true},
+		{pacific, 0, 180, true},
+		{pacific, 0, -180, true},
+		{pacific, 0, 175, true},
+		{pacific, 0, -175, true},
+		{pacific, 0, 185, true},
+		{pacific, 0, 0, false},
+		{pacific, 0, 169, false},
+		{pacific, 0, -169, false},
+		{pacific, 11, 180, false},
+		{WorldBounds, 0, 0, true},
+		{WorldBounds, MaxLatitude, 180, true},
+		{WorldBounds, 89, 0, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.b.Contains(tt.lat, tt.lon); got != tt.want {
+			t.Errorf("%v Contains(%v, %v) = %v, want %v", tt.b, tt.lat, tt.lon, got, tt.want)
+		}
+	}
+}
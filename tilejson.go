@@ -0,0 +1,158 @@
+package pichiwmap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrTileJSONNoTiles is returned when a TileJSON document has no tile URLs
+var ErrTileJSONNoTiles = errors.New("tilejson has no tiles")
+
+// TileJSON describes a tile source
+// https://github.com/mapbox/tilejson-spec
+type TileJSON struct {
+	TileJSON    string    `json:"tilejson"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Version     string    `json:"version"`
+	Attribution string    `json:"attribution"`
+	Scheme      string    `json:"scheme"`
+	Tiles       []string  `json:"tiles"`
+	MinZoom     int       `json:"minzoom"`
+	MaxZoom     int       `json:"maxzoom"`
+	Bounds      []float64 `json:"bounds"`
+	Center      []float64 `json:"center"`
+}
+
+// ParseTileJSON reads a TileJSON document, filling in the spec's defaults for
+// anything that is missing
+func ParseTileJSON(r io.Reader) (*TileJSON, error) {
+	t := &TileJSON{
+		Scheme:  "xyz",
+		MinZoom: 0,
+		MaxZoom: 22,
+		Bounds:  []float64{-180, -85.05112877980659, 180, 85.0511287798066},
+	}
+
+	if err := json.NewDecoder(r).Decode(t); err != nil {
+		return nil, err
+	}
+
+	if len(t.Tiles) == 0 {
+		return nil, ErrTileJSONNoTiles
+	}
+	if t.Scheme != "xyz" && t.Scheme != "tms" {
+		return nil, fmt.Errorf("unsupported tilejson scheme %q", t.Scheme)
+	}
+	if t.MinZoom < 0 || t.MaxZoom < t.MinZoom {
+		return nil, fmt.Errorf("invalid tilejson zoom range %v-%v", t.MinZoom, t.MaxZoom)
+	}
+	if len(t.Bounds) != 4 {
+		return nil, fmt.Errorf("tilejson bounds must have 4 values, got %v", len(t.Bounds))
+	}
+	if len(t.Center) != 0 && len(t.Center) != 3 {
+		return nil, fmt.Errorf("tilejson center must have 3 values, got %v", len(t.Center))
+	}
+
+	return t, nil
+}
+
+// LoadTileJSON fetches and parses the TileJSON document at rawURL. Relative tile
+// URLs are resolved against the document's URL.
+//
+// This blocks, so in the browser it must not be called from an event callback.
+func LoadTileJSON(rawURL string) (*TileJSON, error) {
+	docURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.Get(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("loading tilejson %v: %v", rawURL, resp.Status)
+	}
+
+	t, err := ParseTileJSON(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, tile := range t.Tiles {
+		t.Tiles[i] = resolveTemplate(docURL, tile)
+	}
+
+	return t, nil
+}
+
+// resolveTemplate resolves a relative tile template against base. The template
+// is kept as a string so the {placeholders} don't get escaped.
+func resolveTemplate(base *url.URL, template string) string {
+	if strings.Contains(template, "://") {
+		return template
+	}
+
+	query := ""
+	if i := strings.IndexByte(template, '?'); i >= 0 {
+		template, query = template[:i], template[i:]
+	}
+
+	resolved := base.ResolveReference(&url.URL{Path: template})
+	return resolved.Scheme + "://" + resolved.Host + resolved.Path + query
+}
+
+// URLer creates a URLer for the document's tiles. When there are several tile
+// URLs the requests are spread over all of them. The URLer's MaxNativeZoom is
+// the document's maxzoom.
+func (t *TileJSON) URLer(retina bool) (*Source, error) {
+	var urlErs multiURLer
+	for _, tile := range t.Tiles {
+		if t.Scheme == "tms" {
+			tile = strings.Replace(tile, "{y}", "{-y}", -1)
+		}
+		u, err := NewTemplateURLer(tile, nil, retina)
+		if err != nil {
+			return nil, err
+		}
+		urlErs = append(urlErs, u)
+	}
+
+	if len(urlErs) == 1 {
+		return NewSource(urlErs[0], t.MaxZoom, TileWidth)
+	}
+	return NewSource(urlErs, t.MaxZoom, TileWidth)
+}
+
+// LatLonBounds returns the document's bounds
+func (t *TileJSON) LatLonBounds() Bounds {
+	return Bounds{West: t.Bounds[0], South: t.Bounds[1], East: t.Bounds[2], North: t.Bounds[3]}
+}
+
+// CenterPosition returns the zoom, latitude and longitude from the document's
+// center. ok is false if the document doesn't have a center.
+func (t *TileJSON) CenterPosition() (zoom, lat, lon float64, ok bool) {
+	if len(t.Center) != 3 {
+		return 0, 0, 0, false
+	}
+	return t.Center[2], t.Center[1], t.Center[0], true
+}
+
+// multiURLer spreads tiles over several URLers
+type multiURLer []URLer
+
+func (u multiURLer) URL(zoom, x, y int) *url.URL {
+	i := (x + y) % len(u)
+	if i < 0 {
+		i += len(u)
+	}
+	return u[i].URL(zoom, x, y)
+}
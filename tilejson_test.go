@@ -0,0 +1,96 @@
+package pichiwmap
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseTileJSON(t *testing.T) {
+	doc := `{
+		"tilejson": "2.2.0",
+		"name": "example",
+		"scheme": "tms",
+		"tiles": ["https://a.example.com/{z}/{x}/{y}.png", "https://b.example.com/{z}/{x}/{y}.png"],
+		"minzoom": 2,
+		"maxzoom": 14,
+		"center": [10, 20, 5]
+	}`
+
+	tj, err := ParseTileJSON(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tj.Name != "example" || tj.MinZoom != 2 || tj.MaxZoom != 14 || len(tj.Tiles) != 2 {
+		t.Errorf("parsed %+v", tj)
+	}
+	if b := tj.LatLonBounds(); b.West != -180 || b.East != 180 {
+		t.Errorf("default bounds %+v", b)
+	}
+	if zoom, lat, lon, ok := tj.CenterPosition(); !ok || zoom != 5 || lat != 20 || lon != 10 {
+		t.Errorf("CenterPosition() = %v, %v, %v, %v", zoom, lat, lon, ok)
+	}
+
+	// TMS rows count from the south, and tiles are spread over both hosts
+	u, err := tj.URLer(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := u.URL(3, 2, 1).String(), "https://b.example.com/3/2/6.png"; got != want {
+		t.Errorf("URL(3, 2, 1) = %q, want %q", got, want)
+	}
+	if got, want := u.URL(3, 2, 2).String(), "https://a.example.com/3/2/5.png"; got != want {
+		t.Errorf("URL(3, 2, 2) = %q, want %q", got, want)
+	}
+	if u.MaxNativeZoom() != 14 {
+		t.Errorf("MaxNativeZoom() = %v, want 14", u.MaxNativeZoom())
+	}
+}
+
+func TestParseTileJSONErrors(t *testing.T) {
+	tests := []struct {
+		doc      string
+		err      error
+		contains string
+	}{
+		{doc: `{"tiles": []}`, err: ErrTileJSONNoTiles},
+		{doc: `{}`, err: ErrTileJSONNoTiles},
+		{doc: `{"tiles": ["t"], "scheme": "wmts"}`, contains: `unsupported tilejson scheme "wmts"`},
+		{doc: `{"tiles": ["t"], "minzoom": -1}`, contains: "invalid tilejson zoom range -1-22"},
+		{doc: `{"tiles": ["t"], "minzoom": 10, "maxzoom": 5}`, contains: "invalid tilejson zoom range 10-5"},
+		{doc: `{"tiles": ["t"], "bounds": [1, 2, 3]}`, contains: "tilejson bounds must have 4 values, got 3"},
+		{doc: `{"tiles": ["t"], "center": [1, 2]}`, contains: "tilejson center must have 3 values, got 2"},
+		{doc: `{"tiles": "t"}`, contains: "cannot unmarshal"},
+		{doc: `{"tiles": [`, contains: "unexpected EOF"},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseTileJSON(strings.NewReader(tt.doc))
+		switch {
+		case err == nil:
+			t.Errorf("ParseTileJSON(%v) succeeded, want an error", tt.doc)
+		case tt.err != nil && err != tt.err:
+			t.Errorf("ParseTileJSON(%v) = %v, want %v", tt.doc, err, tt.err)
+		case tt.contains != "" && !strings.Contains(err.Error(), tt.contains):
+			t.Errorf("ParseTileJSON(%v) = %v, want it to contain %q", tt.doc, err, tt.contains)
+		}
+	}
+}
+
+func TestResolveTemplate(t *testing.T) {
+	base, _ := url.Parse("https://example.com/maps/osm.json")
+
+	tests := []struct {
+		template, want string
+	}{
+		{"https://tiles.example.com/{z}/{x}/{y}.png", "https://tiles.example.com/{z}/{x}/{y}.png"},
+		{"tiles/{z}/{x}/{y}.png?key=abc", "https://example.com/maps/tiles/{z}/{x}/{y}.png?key=abc"},
+		{"/tiles/{z}/{x}/{y}.png", "https://example.com/tiles/{z}/{x}/{y}.png"},
+	}
+
+	for _, tt := range tests {
+		if got := resolveTemplate(base, tt.template); got != tt.want {
+			t.Errorf("resolveTemplate(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
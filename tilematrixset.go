@@ -0,0 +1,301 @@
+package pichiwmap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Errors returned from ParseTileMatrixSet when a tile matrix set can't be used
+var (
+	ErrTileMatrixSetEmpty  = errors.New("tile matrix set has no tile matrices")
+	ErrTileMatrixSetOrigin = errors.New("tile matrices must share the same top left origin")
+)
+
+// standardPixelSize is the 0.28mm rendering pixel OGC uses to turn scale
+// denominators into cell sizes
+const standardPixelSize = 0.00028
+
+// knownCRSs are the coordinate reference systems ParseTileMatrixSet can use
+// without being handed a projection
+var knownCRSs = map[string]Projection{
+	"3857":   EPSG3857,
+	"900913": EPSG3857,
+	"4326":   EPSG4326,
+	"CRS84":  EPSG4326,
+}
+
+// TileMatrixSet is a tile grid read from an OGC TileMatrixSet definition. It is a
+// Projection so it can be used anywhere the built in projections are.
+// https://docs.ogc.org/is/17-083r4/17-083r4.html
+type TileMatrixSet struct {
+	ID       string
+	Title    string
+	CRS      string
+	Matrices []TileMatrix
+
+	crs     Projection
+	originX float64
+	originY float64
+}
+
+// TileMatrix is a single zoom level of a TileMatrixSet
+type TileMatrix struct {
+	ID               string
+	ScaleDenominator float64
+	// CellSize is the projected units covered by one pixel
+	CellSize     float64
+	TileWidth    int
+	TileHeight   int
+	MatrixWidth  int
+	MatrixHeight int
+}
+
+type tileMatrixSetJSON struct {
+	// 2.0
+	ID           string           `json:"id"`
+	Title        string           `json:"title"`
+	CRS          json.RawMessage  `json:"crs"`
+	OrderedAxes  []string         `json:"orderedAxes"`
+	TileMatrices []tileMatrixJSON `json:"tileMatrices"`
+
+	// 1.0
+	Identifier   string           `json:"identifier"`
+	SupportedCRS string           `json:"supportedCRS"`
+	TileMatrix   []tileMatrixJSON `json:"tileMatrix"`
+}
+
+type tileMatrixJSON struct {
+	// 2.0
+	ID             string    `json:"id"`
+	CellSize       float64   `json:"cellSize"`
+	CornerOfOrigin string    `json:"cornerOfOrigin"`
+	PointOfOrigin  []float64 `json:"pointOfOrigin"`
+
+	// 1.0
+	Identifier    string    `json:"identifier"`
+	TopLeftCorner []float64 `json:"topLeftCorner"`
+
+	ScaleDenominator float64 `json:"scaleDenominator"`
+	TileWidth        int     `json:"tileWidth"`
+	TileHeight       int     `json:"tileHeight"`
+	MatrixWidth      int     `json:"matrixWidth"`
+	MatrixHeight     int     `json:"matrixHeight"`
+}
+
+// ParseTileMatrixSet reads an OGC TileMatrixSet JSON definition (2.0, or the
+// older 1.0 encoding). crs does the projecting for the tile matrix set's
+// coordinate reference system. If crs is nil the coordinate reference system
+// must be EPSG:3857 or EPSG:4326.
+func ParseTileMatrixSet(r io.Reader, crs Projection) (*TileMatrixSet, error) {
+	var j tileMatrixSetJSON
+	if err := json.NewDecoder(r).Decode(&j); err != nil {
+		return nil, err
+	}
+
+	s := &TileMatrixSet{
+		ID:    j.ID,
+		Title: j.Title,
+		CRS:   j.SupportedCRS,
+	}
+	if s.ID == "" {
+		s.ID = j.Identifier
+	}
+
+	if len(j.CRS) > 0 {
+		crsURI, err := parseCRS(j.CRS)
+		if err != nil {
+			return nil, err
+		}
+		s.CRS = crsURI
+	}
+
+	if crs == nil {
+		crs = knownCRS(s.CRS)
+		if crs == nil {
+			return nil, fmt.Errorf("tile matrix set %q: unsupported crs %q, a projection for it is required", s.ID, s.CRS)
+		}
+	}
+	s.crs = crs
+
+	matrices := j.TileMatrices
+	if len(matrices) == 0 {
+		matrices = j.TileMatrix
+	}
+	if len(matrices) == 0 {
+		return nil, ErrTileMatrixSetEmpty
+	}
+
+	// Latitude first coordinate reference systems list the origin as y, x
+	swapAxes := isNorthingFirst(j.OrderedAxes, s.CRS)
+
+	for i, jm := range matrices {
+		m := TileMatrix{
+			ID:               jm.ID,
+			ScaleDenominator: jm.ScaleDenominator,
+			CellSize:         jm.CellSize,
+			TileWidth:        jm.TileWidth,
+			TileHeight:       jm.TileHeight,
+			MatrixWidth:      jm.MatrixWidth,
+			MatrixHeight:     jm.MatrixHeight,
+		}
+		if m.ID == "" {
+			m.ID = jm.Identifier
+		}
+
+		if jm.CornerOfOrigin != "" && jm.CornerOfOrigin != "topLeft" {
+			return nil, fmt.Errorf("tile matrix %q: unsupported corner of origin %q", m.ID, jm.CornerOfOrigin)
+		}
+		if m.TileWidth <= 0 || m.TileWidth != m.TileHeight {
+			return nil, fmt.Errorf("tile matrix %q: unsupported tile size %vx%v", m.ID, m.TileWidth, m.TileHeight)
+		}
+
+		if m.CellSize == 0 {
+			m.CellSize = m.ScaleDenominator * standardPixelSize / metersPerUnit(crs)
+		}
+		if m.CellSize <= 0 {
+			return nil, fmt.Errorf("tile matrix %q: no cell size or scale denominator", m.ID)
+		}
+
+		origin := jm.PointOfOrigin
+		if len(origin) == 0 {
+			origin = jm.TopLeftCorner
+		}
+		if len(origin) != 2 {
+			return nil, fmt.Errorf("tile matrix %q: origin must have 2 values", m.ID)
+		}
+		ox, oy := origin[0], origin[1]
+		if swapAxes {
+			ox, oy = oy, ox
+		}
+
+		if i == 0 {
+			s.originX, s.originY = ox, oy
+		} else if math.Abs(ox-s.originX) > m.CellSize || math.Abs(oy-s.originY) > m.CellSize {
+			return nil, ErrTileMatrixSetOrigin
+		}
+
+		s.Matrices = append(s.Matrices, m)
+	}
+
+	// Zoom levels go from the coarsest to the finest matrix
+	sort.SliceStable(s.Matrices, func(a, b int) bool {
+		return s.Matrices[a].CellSize > s.Matrices[b].CellSize
+	})
+
+	return s, nil
+}
+
+func parseCRS(raw json.RawMessage) (string, error) {
+	var uri string
+	if err := json.Unmarshal(raw, &uri); err == nil {
+		return uri, nil
+	}
+
+	var ref struct {
+		URI string `json:"uri"`
+	}
+	if err := json.Unmarshal(raw, &ref); err != nil {
+		return "", fmt.Errorf("unsupported tile matrix set crs %s", raw)
+	}
+	return ref.URI, nil
+}
+
+func crsCode(crs string) string {
+	i := strings.LastIndexAny(crs, ":/")
+	return crs[i+1:]
+}
+
+func knownCRS(crs string) Projection {
+	return knownCRSs[crsCode(crs)]
+}
+
+func isNorthingFirst(orderedAxes []string, crs string) bool {
+	if len(orderedAxes) > 0 {
+		switch strings.ToLower(orderedAxes[0]) {
+		case "lat", "latitude", "n", "northing":
+			return true
+		}
+		return false
+	}
+	return crsCode(crs) == "4326"
+}
+
+// metersPerUnit converts scale denominators for geographic coordinate systems,
+// which are in degrees
+func metersPerUnit(crs Projection) float64 {
+	if _, ok := crs.(PlateCarree); ok {
+		return 2 * math.Pi * EarthRadius / 360
+	}
+	return 1
+}
+
+// Matrix returns the tile matrix for zoom, or nil if there isn't one
+func (s *TileMatrixSet) Matrix(zoom int) *TileMatrix {
+	if zoom < 0 || zoom >= len(s.Matrices) {
+		return nil
+	}
+	return &s.Matrices[zoom]
+}
+
+// Project converts latitude and longitude using the tile matrix set's crs
+func (s *TileMatrixSet) Project(lat, lon float64) (x, y float64) {
+	return s.crs.Project(lat, lon)
+}
+
+// Unproject converts x and y using the tile matrix set's crs
+func (s *TileMatrixSet) Unproject(x, y float64) (lat, lon float64) {
+	return s.crs.Unproject(x, y)
+}
+
+// Origin returns the top left corner of the tile matrices
+func (s *TileMatrixSet) Origin() (x, y float64) {
+	return s.originX, s.originY
+}
+
+// Resolution returns the projected units covered by a pixel of a
+// TileWidth x TileHeight tile at zoom. Zoom levels past the last matrix halve
+// its resolution, so the map can still zoom in past it.
+func (s *TileMatrixSet) Resolution(zoom int) float64 {
+	if zoom < 0 {
+		zoom = 0
+	}
+	last := len(s.Matrices) - 1
+	if zoom > last {
+		return s.Resolution(last) / math.Pow(2, float64(zoom-last))
+	}
+	m := s.Matrices[zoom]
+	return m.CellSize * float64(m.TileWidth) / TileWidth
+}
+
+// MatrixSize returns the number of tile columns and rows at zoom. Zoom levels
+// past the last matrix split each of its tiles into four.
+func (s *TileMatrixSet) MatrixSize(zoom int) (cols, rows int) {
+	last := len(s.Matrices) - 1
+	if zoom > last {
+		cols, rows = s.MatrixSize(last)
+		n := 1 << uint(zoom-last)
+		return cols * n, rows * n
+	}
+	m := s.Matrix(zoom)
+	if m == nil {
+		return 0, 0
+	}
+	return m.MatrixWidth, m.MatrixHeight
+}
+
+// MaxNativeZoom returns the zoom level of the last matrix
+func (s *TileMatrixSet) MaxNativeZoom() int {
+	return len(s.Matrices) - 1
+}
+
+// TileAt returns the column and row of the tile containing latitude and
+// longitude at zoom
+func (s *TileMatrixSet) TileAt(zoom int, lat, lon float64) (col, row int) {
+	x, y := TileXY(s, zoom, lat, lon)
+	return int(math.Floor(x)), int(math.Floor(y))
+}
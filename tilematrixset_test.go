@@ -0,0 +1,138 @@
+package pichiwmap
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+const webMercatorQuad = `{
+	"id": "WebMercatorQuad",
+	"title": "Google Maps Compatible for the World",
+	"crs": "http://www.opengis.net/def/crs/EPSG/0/3857",
+	"orderedAxes": ["E", "N"],
+	"tileMatrices": [
+		{
+			"id": "1",
+			"scaleDenominator": 279541132.0143588675418869,
+			"cellSize": 78271.5169639933,
+			"cornerOfOrigin": "topLeft",
+			"pointOfOrigin": [-20037508.3427892, 20037508.3427892],
+			"tileWidth": 256, "tileHeight": 256, "matrixWidth": 2, "matrixHeight": 2
+		},
+		{
+			"id": "0",
+			"scaleDenominator": 559082264.0287178958533332,
+			"cellSize": 156543.033928041,
+			"pointOfOrigin": [-20037508.3427892, 20037508.3427892],
+			"tileWidth": 256, "tileHeight": 256, "matrixWidth": 1, "matrixHeight": 1
+		}
+	]
+}`
+
+const worldCRS84Quad = `{
+	"type": "TileMatrixSetType",
+	"identifier": "WorldCRS84Quad",
+	"supportedCRS": "http://www.opengis.net/def/crs/EPSG/0/4326",
+	"tileMatrix": [
+		{
+			"identifier": "0",
+			"scaleDenominator": 279541132.014358,
+			"topLeftCorner": [90, -180],
+			"tileWidth": 256, "tileHeight": 256, "matrixWidth": 2, "matrixHeight": 1
+		}
+	]
+}`
+
+func TestParseTileMatrixSet(t *testing.T) {
+	s, err := ParseTileMatrixSet(strings.NewReader(webMercatorQuad), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ID != "WebMercatorQuad" || s.CRS != "http://www.opengis.net/def/crs/EPSG/0/3857" {
+		t.Errorf("id %q crs %q", s.ID, s.CRS)
+	}
+
+	// Matrices are sorted coarsest first
+	if len(s.Matrices) != 2 || s.Matrices[0].ID != "0" || s.Matrices[1].ID != "1" {
+		t.Fatalf("matrices %+v", s.Matrices)
+	}
+	if x, y := s.Origin(); x != -20037508.3427892 || y != 20037508.3427892 {
+		t.Errorf("Origin() = %v, %v", x, y)
+	}
+	if cols, rows := s.MatrixSize(3); cols != 8 || rows != 8 {
+		t.Errorf("MatrixSize(3) past the last matrix = %v, %v, want 8, 8", cols, rows)
+	}
+	if col, row := s.TileAt(1, 45, 90); col != 1 || row != 0 {
+		t.Errorf("TileAt(1, 45, 90) = %v, %v, want 1, 0", col, row)
+	}
+}
+
+func TestParseTileMatrixSetGeographic(t *testing.T) {
+	s, err := ParseTileMatrixSet(strings.NewReader(worldCRS84Quad), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ID != "WorldCRS84Quad" {
+		t.Errorf("id %q", s.ID)
+	}
+
+	// EPSG:4326 lists the origin latitude first, and scale denominators are
+	// turned into degrees
+	if x, y := s.Origin(); x != -180 || y != 90 {
+		t.Errorf("Origin() = %v, %v, want -180, 90", x, y)
+	}
+	if res := s.Resolution(0); math.Abs(res-180.0/256) > 1e-9 {
+		t.Errorf("Resolution(0) = %v, want %v", res, 180.0/256)
+	}
+}
+
+func TestParseTileMatrixSetErrors(t *testing.T) {
+	matrix := func(fields string) string {
+		return `{"crs": "EPSG:3857", "tileMatrices": [{"id": "0", "cellSize": 1, "pointOfOrigin": [0, 0], "tileWidth": 256, "tileHeight": 256, ` + fields + `}]}`
+	}
+
+	tests := []struct {
+		name     string
+		doc      string
+		err      error
+		contains string
+	}{
+		{name: "no matrices", doc: `{"crs": "EPSG:3857", "tileMatrices": []}`, err: ErrTileMatrixSetEmpty},
+		{
+			name: "mismatched origins",
+			doc: `{"crs": "EPSG:3857", "tileMatrices": [
+				{"id": "0", "cellSize": 2, "pointOfOrigin": [0, 0], "tileWidth": 256, "tileHeight": 256},
+				{"id": "1", "cellSize": 1, "pointOfOrigin": [100, 0], "tileWidth": 256, "tileHeight": 256}
+			]}`,
+			err: ErrTileMatrixSetOrigin,
+		},
+		{name: "unknown crs", doc: `{"id": "utm", "crs": "EPSG:32633", "tileMatrices": []}`, contains: `tile matrix set "utm": unsupported crs "EPSG:32633"`},
+		{name: "bad crs", doc: `{"crs": 3857}`, contains: "unsupported tile matrix set crs 3857"},
+		{name: "bottom left", doc: matrix(`"cornerOfOrigin": "bottomLeft"`), contains: `unsupported corner of origin "bottomLeft"`},
+		{name: "tile size", doc: `{"crs": "EPSG:3857", "tileMatrices": [{"id": "0", "cellSize": 1, "pointOfOrigin": [0, 0], "tileWidth": 256, "tileHeight": 512}]}`, contains: "unsupported tile size 256x512"},
+		{name: "no cell size", doc: `{"crs": "EPSG:3857", "tileMatrices": [{"id": "0", "pointOfOrigin": [0, 0], "tileWidth": 256, "tileHeight": 256}]}`, contains: "no cell size or scale denominator"},
+		{name: "origin", doc: `{"crs": "EPSG:3857", "tileMatrices": [{"id": "0", "cellSize": 1, "pointOfOrigin": [0], "tileWidth": 256, "tileHeight": 256}]}`, contains: "origin must have 2 values"},
+		{name: "json", doc: `{"crs": `, contains: "unexpected EOF"},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseTileMatrixSet(strings.NewReader(tt.doc), nil)
+		switch {
+		case err == nil:
+			t.Errorf("%v: succeeded, want an error", tt.name)
+		case tt.err != nil && err != tt.err:
+			t.Errorf("%v: %v, want %v", tt.name, err, tt.err)
+		case tt.contains != "" && !strings.Contains(err.Error(), tt.contains):
+			t.Errorf("%v: %v, want it to contain %q", tt.name, err, tt.contains)
+		}
+	}
+}
+
+func TestViewTileRange(t *testing.T) {
+	// A view just inside the whole world at zoom 1, with a tile of margin
+	minCol, minRow, maxCol, maxRow := ViewTileRange(EPSG3857, 1, 1, 0, 0, 500, 500)
+	if minCol != -1 || minRow != -1 || maxCol != 2 || maxRow != 2 {
+		t.Errorf("ViewTileRange = %v, %v, %v, %v, want -1, -1, 2, 2", minCol, minRow, maxCol, maxRow)
+	}
+}
@@ -1,8 +1,12 @@
 package pichiwmap
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
+	"path"
+	"strconv"
+	"strings"
 )
 
 // NewOpenStreetMapURLer creates an OpenStreetMap
@@ -22,3 +26,307 @@ func (u *OpenStreetMapURLer) URL(zoom, x, y int) *url.URL {
 	mapURL.Path = fmt.Sprintf("%v/%v/%v.png", zoom, x, y)
 	return &mapURL
 }
+
+// Errors returned from NewTemplateURLer when a template can't be used
+var (
+	ErrTemplateMissingZXY     = errors.New("template must contain {z}, {x} and {y} or {-y}, or {q}")
+	ErrTemplateEmptySubdomain = errors.New("template subdomains can't be empty")
+	ErrTemplateUnclosedBrace  = errors.New("template has an unclosed {")
+)
+
+// DefaultSubdomains are used by NewTemplateURLer when no subdomains are given
+var DefaultSubdomains = []string{"a", "b", "c"}
+
+// RetinaSuffix is substituted for {r} in templates when retina tiles are requested
+const RetinaSuffix = "@2x"
+
+type templatePart int
+
+const (
+	templateLiteral templatePart = iota
+	templateZ
+	templateX
+	templateY
+	templateFlippedY
+	templateSubdomain
+	templateRetina
+	templateQuadkey
+)
+
+var templatePlaceholders = map[string]templatePart{
+	"z":  templateZ,
+	"x":  templateX,
+	"y":  templateY,
+	"-y": templateFlippedY,
+	"s":  templateSubdomain,
+	"r":  templateRetina,
+	"q":  templateQuadkey,
+}
+
+type templateSegment struct {
+	part    templatePart
+	literal string
+}
+
+// NewTemplateURLer creates a URLer from a Leaflet style template such as
+// "https://{s}.tile.example.com/{z}/{x}/{y}{r}.png?key=abc".
+//
+// Supported placeholders are {z}, {x}, {y}, {-y} (TMS flipped y), {s} (a subdomain
+// picked from subdomains), {r} (RetinaSuffix when retina is true) and {q} (a Bing
+// quadkey, which may be used instead of {z}, {x} and {y}). If subdomains is empty
+// DefaultSubdomains are used.
+func NewTemplateURLer(template string, subdomains []string, retina bool) (*TemplateURLer, error) {
+	if len(subdomains) == 0 {
+		subdomains = DefaultSubdomains
+	}
+
+	segments, err := parseTemplate(template)
+	if err != nil {
+		return nil, err
+	}
+
+	var hasZ, hasX, hasY, hasQ, hasS bool
+	for _, s := range segments {
+		switch s.part {
+		case templateZ:
+			hasZ = true
+		case templateX:
+			hasX = true
+		case templateY, templateFlippedY:
+			hasY = true
+		case templateSubdomain:
+			hasS = true
+		case templateQuadkey:
+			hasQ = true
+		}
+	}
+
+	if !hasQ && (!hasZ || !hasX || !hasY) {
+		return nil, ErrTemplateMissingZXY
+	}
+
+	for _, s := range subdomains {
+		if s == "" {
+			return nil, ErrTemplateEmptySubdomain
+		}
+	}
+
+	u := &TemplateURLer{
+		template:   template,
+		subdomains: subdomains,
+		retina:     retina,
+		prefix:     tokenPrefix(template),
+	}
+
+	if !hasS {
+		u.subdomains = nil
+	}
+
+	// Parse the template once with each placeholder swapped for a token, so the
+	// shape of the URL is fixed and URL only has to replace the tokens
+	var sb strings.Builder
+	seen := map[templatePart]bool{}
+	for _, s := range segments {
+		if s.part == templateLiteral {
+			sb.WriteString(s.literal)
+			continue
+		}
+		sb.WriteString(u.token(s.part))
+		if !seen[s.part] {
+			seen[s.part] = true
+			u.parts = append(u.parts, s.part)
+		}
+	}
+
+	base, err := url.Parse(sb.String())
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("invalid template %q: %v", template, err)
+	}
+	u.base = base
+
+	return u, nil
+}
+
+// tokenPrefix returns a prefix that doesn't appear in template. Tokens are the
+// prefix and a digit, letters and digits that url.Parse leaves alone wherever
+// they are in a URL.
+func tokenPrefix(template string) string {
+	prefix := "pichiwmap"
+	for strings.Contains(template, prefix) {
+		prefix += "0"
+	}
+	return prefix
+}
+
+func parseTemplate(template string) ([]templateSegment, error) {
+	var segments []templateSegment
+
+	rest := template
+	for rest != "" {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			segments = append(segments, templateSegment{literal: rest})
+			break
+		}
+		if start > 0 {
+			segments = append(segments, templateSegment{literal: rest[:start]})
+		}
+
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, ErrTemplateUnclosedBrace
+		}
+		end += start
+
+		name := rest[start+1 : end]
+		part, ok := templatePlaceholders[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown template placeholder {%v}", name)
+		}
+		segments = append(segments, templateSegment{part: part})
+		rest = rest[end+1:]
+	}
+
+	return segments, nil
+}
+
+// TemplateURLer calculates a URL by filling in a template
+type TemplateURLer struct {
+	template   string
+	base       *url.URL
+	prefix     string
+	parts      []templatePart
+	subdomains []string
+	retina     bool
+}
+
+// Template returns the template the URLer was created with
+func (u *TemplateURLer) Template() string {
+	return u.template
+}
+
+// Subdomain returns the subdomain used for the tile at x and y. Subdomains are
+// rotated per tile so neighbouring tiles are spread over all hosts while the
+// same tile always maps to the same host (and so the same browser cache entry).
+func (u *TemplateURLer) Subdomain(x, y int) string {
+	if len(u.subdomains) == 0 {
+		return ""
+	}
+	i := (x + y) % len(u.subdomains)
+	if i < 0 {
+		i += len(u.subdomains)
+	}
+	return u.subdomains[i]
+}
+
+func (u *TemplateURLer) token(part templatePart) string {
+	return u.prefix + strconv.Itoa(int(part))
+}
+
+func (u *TemplateURLer) value(part templatePart, zoom, x, y int) string {
+	switch part {
+	case templateZ:
+		return strconv.Itoa(zoom)
+	case templateX:
+		return strconv.Itoa(x)
+	case templateY:
+		return strconv.Itoa(y)
+	case templateFlippedY:
+		return strconv.Itoa(TMSY(zoom, y))
+	case templateSubdomain:
+		return u.Subdomain(x, y)
+	case templateRetina:
+		if u.retina {
+			return RetinaSuffix
+		}
+	case templateQuadkey:
+		return Quadkey(zoom, x, y)
+	}
+	return ""
+}
+
+// URL calculates a URL from zoom, x, and y
+func (u *TemplateURLer) URL(zoom, x, y int) *url.URL {
+	replacements := make([]string, 0, 2*len(u.parts))
+	for _, part := range u.parts {
+		replacements = append(replacements, u.token(part), u.value(part, zoom, x, y))
+	}
+	return replaceURL(u.base, strings.NewReplacer(replacements...))
+}
+
+// replaceURL returns a copy of base with r applied to each part of it
+func replaceURL(base *url.URL, r *strings.Replacer) *url.URL {
+	mapURL := *base
+	mapURL.Opaque = r.Replace(mapURL.Opaque)
+	mapURL.Host = r.Replace(mapURL.Host)
+	mapURL.Path = r.Replace(mapURL.Path)
+	mapURL.RawPath = r.Replace(mapURL.RawPath)
+	mapURL.RawQuery = r.Replace(mapURL.RawQuery)
+	mapURL.Fragment = r.Replace(mapURL.Fragment)
+	return &mapURL
+}
+
+// ErrTemplateMissingQuadkey is returned from NewQuadkeyURLer when the template has no {q}
+var ErrTemplateMissingQuadkey = errors.New("template must contain {q}")
+
+// BingSubdomains are the subdomains Bing's tile servers are spread over
+var BingSubdomains = []string{"0", "1", "2", "3"}
+
+// NewQuadkeyURLer creates a URLer for Bing style quadkey addressed tiles, such as
+// "https://ecn.t{s}.tiles.virtualearth.net/tiles/a{q}.jpeg?g=1". If subdomains
+// is empty BingSubdomains are used.
+func NewQuadkeyURLer(template string, subdomains []string) (*QuadkeyURLer, error) {
+	if !strings.Contains(template, "{q}") {
+		return nil, ErrTemplateMissingQuadkey
+	}
+	if len(subdomains) == 0 {
+		subdomains = BingSubdomains
+	}
+
+	t, err := NewTemplateURLer(template, subdomains, false)
+	if err != nil {
+		return nil, err
+	}
+	return &QuadkeyURLer{template: t}, nil
+}
+
+// QuadkeyURLer calculates a URL based on Bing's quadkey spec
+// https://docs.microsoft.com/en-us/bingmaps/articles/bing-maps-tile-system
+type QuadkeyURLer struct {
+	template *TemplateURLer
+}
+
+// URL calculates a URL from zoom, x, and y
+func (u *QuadkeyURLer) URL(zoom, x, y int) *url.URL {
+	return u.template.URL(zoom, x, y)
+}
+
+// NewTMSURLer creates a TMS URLer. Tiles are requested from
+// baseURL/{z}/{x}/{y}.{extension} where y counts up from the south.
+func NewTMSURLer(baseURL *url.URL, extension string) *TMSURLer {
+	return &TMSURLer{baseURL: baseURL, extension: extension}
+}
+
+// TMSURLer calculates a URL based on the Tile Map Service spec, which numbers
+// rows from the bottom of the map rather than the top
+// https://wiki.osgeo.org/wiki/Tile_Map_Service_Specification
+type TMSURLer struct {
+	baseURL   *url.URL
+	extension string
+}
+
+// URL calculates a URL from zoom, x, and y
+func (u *TMSURLer) URL(zoom, x, y int) *url.URL {
+	mapURL := *u.baseURL
+	mapURL.Path = path.Join(
+		"/",
+		mapURL.Path,
+		strconv.Itoa(zoom),
+		strconv.Itoa(x),
+		fmt.Sprintf("%v.%v", TMSY(zoom, y), u.extension),
+	)
+	return &mapURL
+}
@@ -0,0 +1,148 @@
+package pichiwmap
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestTemplateURLer(t *testing.T) {
+	tests := []struct {
+		template   string
+		subdomains []string
+		retina     bool
+		zoom, x, y int
+		want       string
+	}{
+		{"https://tile.example.com/{z}/{x}/{y}.png", nil, false, 3, 2, 1, "https://tile.example.com/3/2/1.png"},
+		{"https://{s}.tile.example.com/{z}/{x}/{y}.png", nil, false, 3, 2, 1, "https://a.tile.example.com/3/2/1.png"},
+		{"https://{s}.tile.example.com/{z}/{x}/{y}.png", []string{"x", "y"}, false, 3, 2, 1, "https://y.tile.example.com/3/2/1.png"},
+		{"https://tile.example.com/{z}/{x}/{y}{r}.png", nil, true, 3, 2, 1, "https://tile.example.com/3/2/1@2x.png"},
+		{"https://tile.example.com/{z}/{x}/{y}{r}.png", nil, false, 3, 2, 1, "https://tile.example.com/3/2/1.png"},
+		{"https://tile.example.com/{z}/{x}/{-y}.png?key=abc", nil, false, 3, 2, 1, "https://tile.example.com/3/2/6.png?key=abc"},
+		{"https://tile.example.com/{q}.jpeg", nil, false, 3, 2, 1, "https://tile.example.com/012.jpeg"},
+		{"https://tile.example.com/tiles?z={z}&x={x}&y={y}#{z}", nil, false, 3, 2, 1, "https://tile.example.com/tiles?z=3&x=2&y=1#3"},
+		{"https://tile.example.com/{z}1/{x}/{y}.png", nil, false, 3, 2, 1, "https://tile.example.com/31/2/1.png"},
+		{"https://tile.example.com/pichiwmap/{z}/{x}/{y}.png", nil, false, 3, 2, 1, "https://tile.example.com/pichiwmap/3/2/1.png"},
+		{"https://tile.example.com/a%2Fb/{z}/{x}/{y}{r}.png", nil, true, 3, 2, 1, "https://tile.example.com/a%2Fb/3/2/1@2x.png"},
+	}
+
+	for _, tt := range tests {
+		u, err := NewTemplateURLer(tt.template, tt.subdomains, tt.retina)
+		if err != nil {
+			t.Errorf("NewTemplateURLer(%q): %v", tt.template, err)
+			continue
+		}
+		if got := u.URL(tt.zoom, tt.x, tt.y).String(); got != tt.want {
+			t.Errorf("%q URL(%v, %v, %v) = %q, want %q", tt.template, tt.zoom, tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+func TestTemplateURLerErrors(t *testing.T) {
+	tests := []struct {
+		template   string
+		subdomains []string
+		err        error
+		contains   string
+	}{
+		{template: "https://tile.example.com/{z}/{x}.png", err: ErrTemplateMissingZXY},
+		{template: "https://tile.example.com/{z}/{x}/{y.png", err: ErrTemplateUnclosedBrace},
+		{template: "https://{s}.tile.example.com/{z}/{x}/{y}.png", subdomains: []string{"a", ""}, err: ErrTemplateEmptySubdomain},
+		{template: "https://tile.example.com/{z}/{x}/{y}/{t}.png", contains: "unknown template placeholder {t}"},
+		{template: "https://tile example.com:port/{z}/{x}/{y}.png", contains: "invalid template"},
+	}
+
+	for _, tt := range tests {
+		_, err := NewTemplateURLer(tt.template, tt.subdomains, false)
+		switch {
+		case err == nil:
+			t.Errorf("NewTemplateURLer(%q) succeeded, want an error", tt.template)
+		case tt.err != nil && err != tt.err:
+			t.Errorf("NewTemplateURLer(%q) = %v, want %v", tt.template, err, tt.err)
+		case tt.contains != "" && !strings.Contains(err.Error(), tt.contains):
+			t.Errorf("NewTemplateURLer(%q) = %v, want it to contain %q", tt.template, err, tt.contains)
+		}
+	}
+}
+
+func TestTemplateURLerSubdomain(t *testing.T) {
+	u, err := NewTemplateURLer("https://{s}.example.com/{z}/{x}/{y}.png", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Neighbours are spread over the hosts, negative world copies too
+	tests := []struct {
+		x, y int
+		want string
+	}{
+		{0, 0, "a"},
+		{1, 0, "b"},
+		{0, 1, "b"},
+		{2, 0, "c"},
+		{5, 7, "a"},
+		{-1, 0, "c"},
+		{-4, 2, "b"},
+		{-3, 0, "a"},
+	}
+
+	for _, tt := range tests {
+		if got := u.Subdomain(tt.x, tt.y); got != tt.want {
+			t.Errorf("Subdomain(%v, %v) = %q, want %q", tt.x, tt.y, got, tt.want)
+		}
+		want := "https://" + tt.want + ".example.com/3/"
+		if got := u.URL(3, tt.x, tt.y).String(); !strings.HasPrefix(got, want) {
+			t.Errorf("URL(3, %v, %v) = %q, want it to start %q", tt.x, tt.y, got, want)
+		}
+	}
+}
+
+func TestTMSURLer(t *testing.T) {
+	base, err := url.Parse("https://tms.example.com/tiles/osm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := NewTMSURLer(base, "png")
+
+	tests := []struct {
+		zoom, x, y int
+		want       string
+	}{
+		{0, 0, 0, "https://tms.example.com/tiles/osm/0/0/0.png"},
+		{1, 1, 0, "https://tms.example.com/tiles/osm/1/1/1.png"},
+		{3, 2, 1, "https://tms.example.com/tiles/osm/3/2/6.png"},
+	}
+
+	for _, tt := range tests {
+		if got := u.URL(tt.zoom, tt.x, tt.y).String(); got != tt.want {
+			t.Errorf("URL(%v, %v, %v) = %q, want %q", tt.zoom, tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+func TestQuadkeyURLer(t *testing.T) {
+	u, err := NewQuadkeyURLer("https://ecn.t{s}.tiles.virtualearth.net/tiles/a{q}.jpeg?g=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		zoom, x, y int
+		want       string
+	}{
+		{1, 0, 0, "https://ecn.t0.tiles.virtualearth.net/tiles/a0.jpeg?g=1"},
+		{3, 3, 5, "https://ecn.t0.tiles.virtualearth.net/tiles/a213.jpeg?g=1"},
+		{3, 2, 1, "https://ecn.t3.tiles.virtualearth.net/tiles/a012.jpeg?g=1"},
+	}
+
+	for _, tt := range tests {
+		if got := u.URL(tt.zoom, tt.x, tt.y).String(); got != tt.want {
+			t.Errorf("URL(%v, %v, %v) = %q, want %q", tt.zoom, tt.x, tt.y, got, tt.want)
+		}
+	}
+
+	if _, err := NewQuadkeyURLer("https://tiles.example.com/{z}/{x}/{y}.png", nil); err != ErrTemplateMissingQuadkey {
+		t.Errorf("NewQuadkeyURLer without {q} = %v, want %v", err, ErrTemplateMissingQuadkey)
+	}
+}
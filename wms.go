@@ -0,0 +1,133 @@
+package pichiwmap
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Supported WMS versions
+const (
+	WMSVersion111 = "1.1.1"
+	WMSVersion130 = "1.3.0"
+)
+
+// Errors returned from NewWMSURLer when the options can't be used
+var (
+	ErrWMSNoLayers = errors.New("wms requires at least one layer")
+	ErrWMSStyles   = errors.New("wms styles must be empty or match the number of layers")
+)
+
+// WMSOptions configures the GetMap requests made by a WMSURLer
+type WMSOptions struct {
+	// Layers are the WMS layers to render, at least one is required
+	Layers []string
+	// Styles are the styles for each layer, leave empty for the default styles
+	Styles []string
+	// Format is the image format to request, defaults to image/png
+	Format string
+	// Transparent requests a transparent background
+	Transparent bool
+	// Version is WMSVersion111 or WMSVersion130, defaults to WMSVersion111
+	Version string
+	// TileSize is the width and height of the images to request, defaults to
+	// TileWidth. Bigger tiles cover more ground, so fewer requests are made.
+	TileSize int
+}
+
+// NewWMSURLer creates a URLer that requests each tile from a WMS server with a
+// GetMap request in EPSG:3857. Any query parameters in baseURL (such as API keys)
+// are kept.
+func NewWMSURLer(baseURL *url.URL, options WMSOptions) (*WMSURLer, error) {
+	if len(options.Layers) == 0 {
+		return nil, ErrWMSNoLayers
+	}
+	if len(options.Styles) != 0 && len(options.Styles) != len(options.Layers) {
+		return nil, ErrWMSStyles
+	}
+	if options.Format == "" {
+		options.Format = "image/png"
+	}
+	if options.Version == "" {
+		options.Version = WMSVersion111
+	}
+	if options.TileSize == 0 {
+		options.TileSize = TileWidth
+	}
+	if !ValidTileSize(options.TileSize) {
+		return nil, ErrInvalidTileSize
+	}
+
+	// 1.1.1 calls the coordinate system SRS, 1.3.0 renamed it to CRS
+	var crsParam string
+	switch options.Version {
+	case WMSVersion111:
+		crsParam = "SRS"
+	case WMSVersion130:
+		crsParam = "CRS"
+	default:
+		return nil, fmt.Errorf("unsupported wms version %q", options.Version)
+	}
+
+	query := baseURL.Query()
+	query.Set("SERVICE", "WMS")
+	query.Set("REQUEST", "GetMap")
+	query.Set("VERSION", options.Version)
+	query.Set("LAYERS", strings.Join(options.Layers, ","))
+	query.Set("STYLES", strings.Join(options.Styles, ","))
+	query.Set("FORMAT", options.Format)
+	query.Set("TRANSPARENT", strings.ToUpper(strconv.FormatBool(options.Transparent)))
+	query.Set("WIDTH", strconv.Itoa(options.TileSize))
+	query.Set("HEIGHT", strconv.Itoa(options.TileSize))
+	query.Set(crsParam, "EPSG:3857")
+
+	return &WMSURLer{
+		baseURL:  baseURL,
+		query:    query,
+		version:  options.Version,
+		tileSize: options.TileSize,
+	}, nil
+}
+
+// WMSURLer calculates GetMap URLs for tiles from a WMS server
+// http://www.opengeospatial.org/standards/wms
+type WMSURLer struct {
+	baseURL  *url.URL
+	query    url.Values
+	version  string
+	tileSize int
+}
+
+// TileSize returns the width and height of the images requested
+func (u *WMSURLer) TileSize() int {
+	return u.tileSize
+}
+
+// BBox returns the BBOX parameter for the tile at zoom, x, and y.
+//
+// EPSG:3857 is easting/northing in both 1.1.1 and 1.3.0, so unlike geographic
+// coordinate systems the axis order doesn't change between versions.
+func (u *WMSURLer) BBox(zoom, x, y int) string {
+	minX, minY, maxX, maxY := MercatorBounds(zoom, x, y)
+	return strings.Join([]string{
+		strconv.FormatFloat(minX, 'f', -1, 64),
+		strconv.FormatFloat(minY, 'f', -1, 64),
+		strconv.FormatFloat(maxX, 'f', -1, 64),
+		strconv.FormatFloat(maxY, 'f', -1, 64),
+	}, ",")
+}
+
+// URL calculates a URL from zoom, x, and y
+func (u *WMSURLer) URL(zoom, x, y int) *url.URL {
+	query := url.Values{}
+	for k, v := range u.query {
+		query[k] = v
+	}
+	query.Set("BBOX", u.BBox(zoom, x, y))
+
+	mapURL := *u.baseURL
+	mapURL.RawQuery = query.Encode()
+	return &mapURL
+}
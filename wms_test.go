@@ -0,0 +1,114 @@
+package pichiwmap
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWMSURLer(t *testing.T) {
+	base, err := url.Parse("https://wms.example.com/service?key=abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		options WMSOptions
+		want    map[string]string
+	}{
+		{
+			WMSOptions{Layers: []string{"roads", "rivers"}},
+			map[string]string{
+				"key":         "abc",
+				"SERVICE":     "WMS",
+				"REQUEST":     "GetMap",
+				"VERSION":     "1.1.1",
+				"LAYERS":      "roads,rivers",
+				"STYLES":      "",
+				"FORMAT":      "image/png",
+				"TRANSPARENT": "FALSE",
+				"WIDTH":       "256",
+				"HEIGHT":      "256",
+				"SRS":         "EPSG:3857",
+				"BBOX":        "-20037508.342789244,-20037508.342789244,20037508.342789244,20037508.342789244",
+			},
+		},
+		{
+			WMSOptions{Layers: []string{"roads"}, Styles: []string{"dark"}, Format: "image/jpeg", Transparent: true, Version: WMSVersion130},
+			map[string]string{
+				"VERSION":     "1.3.0",
+				"STYLES":      "dark",
+				"FORMAT":      "image/jpeg",
+				"TRANSPARENT": "TRUE",
+				"CRS":         "EPSG:3857",
+			},
+		},
+		{
+			WMSOptions{Layers: []string{"roads"}, TileSize: 512},
+			map[string]string{
+				"WIDTH":  "512",
+				"HEIGHT": "512",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		u, err := NewWMSURLer(base, tt.options)
+		if err != nil {
+			t.Errorf("NewWMSURLer(%+v): %v", tt.options, err)
+			continue
+		}
+		if want := tt.want["WIDTH"]; want != "" && strconv.Itoa(u.TileSize()) != want {
+			t.Errorf("NewWMSURLer(%+v) TileSize() = %v, want %v", tt.options, u.TileSize(), want)
+		}
+		query := u.URL(0, 0, 0).Query()
+		for k, want := range tt.want {
+			if got := query.Get(k); got != want {
+				t.Errorf("NewWMSURLer(%+v) %v = %q, want %q", tt.options, k, got, want)
+			}
+		}
+	}
+}
+
+func TestWMSURLerBBox(t *testing.T) {
+	base, _ := url.Parse("https://wms.example.com/service")
+	u, err := NewWMSURLer(base, WMSOptions{Layers: []string{"roads"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The north east quarter of the world at zoom 1
+	want := "0,0,20037508.342789244,20037508.342789244"
+	if got := u.BBox(1, 1, 0); got != want {
+		t.Errorf("BBox(1, 1, 0) = %q, want %q", got, want)
+	}
+}
+
+func TestWMSURLerErrors(t *testing.T) {
+	base, _ := url.Parse("https://wms.example.com/service")
+
+	tests := []struct {
+		options  WMSOptions
+		err      error
+		contains string
+	}{
+		{options: WMSOptions{}, err: ErrWMSNoLayers},
+		{options: WMSOptions{Layers: []string{"roads", "rivers"}, Styles: []string{"dark"}}, err: ErrWMSStyles},
+		{options: WMSOptions{Layers: []string{"roads"}, Styles: []string{"a", "b"}}, err: ErrWMSStyles},
+		{options: WMSOptions{Layers: []string{"roads"}, Version: "1.0.0"}, contains: `unsupported wms version "1.0.0"`},
+		{options: WMSOptions{Layers: []string{"roads"}, TileSize: 300}, err: ErrInvalidTileSize},
+	}
+
+	for _, tt := range tests {
+		_, err := NewWMSURLer(base, tt.options)
+		switch {
+		case err == nil:
+			t.Errorf("NewWMSURLer(%+v) succeeded, want an error", tt.options)
+		case tt.err != nil && err != tt.err:
+			t.Errorf("NewWMSURLer(%+v) = %v, want %v", tt.options, err, tt.err)
+		case tt.contains != "" && !strings.Contains(err.Error(), tt.contains):
+			t.Errorf("NewWMSURLer(%+v) = %v, want it to contain %q", tt.options, err, tt.contains)
+		}
+	}
+}
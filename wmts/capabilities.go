@@ -0,0 +1,181 @@
+// Package wmts reads OGC WMTS GetCapabilities documents and builds tile URLers
+// for the layers they describe.
+// http://www.opengeospatial.org/standards/wmts
+package wmts
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Parse reads a GetCapabilities document
+func Parse(r io.Reader) (*Capabilities, error) {
+	var c Capabilities
+	if err := xml.NewDecoder(r).Decode(&c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Capabilities is a parsed GetCapabilities document
+type Capabilities struct {
+	Version        string          `xml:"version,attr"`
+	Title          string          `xml:"ServiceIdentification>Title"`
+	Operations     []Operation     `xml:"OperationsMetadata>Operation"`
+	Layers         []Layer         `xml:"Contents>Layer"`
+	TileMatrixSets []TileMatrixSet `xml:"Contents>TileMatrixSet"`
+}
+
+// Layer returns the layer with the identifier, or nil if there isn't one
+func (c *Capabilities) Layer(identifier string) *Layer {
+	for i := range c.Layers {
+		if c.Layers[i].Identifier == identifier {
+			return &c.Layers[i]
+		}
+	}
+	return nil
+}
+
+// TileMatrixSet returns the tile matrix set with the identifier, or nil if there isn't one
+func (c *Capabilities) TileMatrixSet(identifier string) *TileMatrixSet {
+	for i := range c.TileMatrixSets {
+		if c.TileMatrixSets[i].Identifier == identifier {
+			return &c.TileMatrixSets[i]
+		}
+	}
+	return nil
+}
+
+// KVPEndpoint returns the GetTile URL for key-value-pair requests, or "" if the
+// server doesn't support them
+func (c *Capabilities) KVPEndpoint() string {
+	for _, o := range c.Operations {
+		if o.Name != "GetTile" {
+			continue
+		}
+		for _, g := range o.Get {
+			if len(g.Encodings) == 0 {
+				return g.Href
+			}
+			for _, e := range g.Encodings {
+				if strings.EqualFold(e, "KVP") {
+					return g.Href
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// Operation is an operation the server supports
+type Operation struct {
+	Name string `xml:"name,attr"`
+	Get  []Get  `xml:"DCP>HTTP>Get"`
+}
+
+// Get is an HTTP GET endpoint for an operation
+type Get struct {
+	Href      string   `xml:"href,attr"`
+	Encodings []string `xml:"Constraint>AllowedValues>Value"`
+}
+
+// Layer is a layer the server can serve tiles for
+type Layer struct {
+	Identifier     string           `xml:"Identifier"`
+	Title          string           `xml:"Title"`
+	Abstract       string           `xml:"Abstract"`
+	Styles         []Style          `xml:"Style"`
+	Formats        []string         `xml:"Format"`
+	Dimensions     []Dimension      `xml:"Dimension"`
+	TileMatrixSets []string         `xml:"TileMatrixSetLink>TileMatrixSet"`
+	ResourceURLs   []ResourceURL    `xml:"ResourceURL"`
+	WGS84Bounds    WGS84BoundingBox `xml:"WGS84BoundingBox"`
+}
+
+// DefaultStyle returns the identifier of the layer's default style
+func (l *Layer) DefaultStyle() string {
+	for _, s := range l.Styles {
+		if s.IsDefault {
+			return s.Identifier
+		}
+	}
+	if len(l.Styles) > 0 {
+		return l.Styles[0].Identifier
+	}
+	return ""
+}
+
+// TileTemplates returns the RESTful tile URL templates for the layer
+func (l *Layer) TileTemplates() []ResourceURL {
+	var templates []ResourceURL
+	for _, r := range l.ResourceURLs {
+		if r.ResourceType == "tile" {
+			templates = append(templates, r)
+		}
+	}
+	return templates
+}
+
+// Style is a style a layer can be rendered in
+type Style struct {
+	Identifier string `xml:"Identifier"`
+	Title      string `xml:"Title"`
+	IsDefault  bool   `xml:"isDefault,attr"`
+}
+
+// Dimension is an extra dimension of a layer, such as time or elevation
+type Dimension struct {
+	Identifier string   `xml:"Identifier"`
+	Default    string   `xml:"Default"`
+	Values     []string `xml:"Value"`
+}
+
+// ResourceURL is a RESTful URL template for a layer
+type ResourceURL struct {
+	Format       string `xml:"format,attr"`
+	ResourceType string `xml:"resourceType,attr"`
+	Template     string `xml:"template,attr"`
+}
+
+// WGS84BoundingBox is the extent of a layer in longitude/latitude
+type WGS84BoundingBox struct {
+	LowerCorner Point `xml:"LowerCorner"`
+	UpperCorner Point `xml:"UpperCorner"`
+}
+
+// TileMatrixSet is a set of tile grids, one per zoom level
+type TileMatrixSet struct {
+	Identifier        string       `xml:"Identifier"`
+	SupportedCRS      string       `xml:"SupportedCRS"`
+	WellKnownScaleSet string       `xml:"WellKnownScaleSet"`
+	TileMatrices      []TileMatrix `xml:"TileMatrix"`
+}
+
+// TileMatrix is a single tile grid of a TileMatrixSet
+type TileMatrix struct {
+	Identifier       string  `xml:"Identifier"`
+	ScaleDenominator float64 `xml:"ScaleDenominator"`
+	TopLeftCorner    Point   `xml:"TopLeftCorner"`
+	TileWidth        int     `xml:"TileWidth"`
+	TileHeight       int     `xml:"TileHeight"`
+	MatrixWidth      int     `xml:"MatrixWidth"`
+	MatrixHeight     int     `xml:"MatrixHeight"`
+}
+
+// Point is a space separated pair of coordinates, such as "-180 -90"
+type Point [2]float64
+
+// UnmarshalText parses the coordinates
+func (p *Point) UnmarshalText(text []byte) error {
+	fields := strings.Fields(string(text))
+	for i := 0; i < len(fields) && i < 2; i++ {
+		v, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return err
+		}
+		p[i] = v
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package wmts
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func parseFixture(t *testing.T, name string) *Capabilities {
+	t.Helper()
+
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	c, err := Parse(f)
+	if err != nil {
+		t.Fatalf("Parse(%v): %v", name, err)
+	}
+	return c
+}
+
+func TestParse(t *testing.T) {
+	c := parseFixture(t, "restful.xml")
+
+	if c.Version != "1.0.0" || c.Title != "Example RESTful WMTS" {
+		t.Errorf("version %q title %q", c.Version, c.Title)
+	}
+
+	l := c.Layer("imagery")
+	if l == nil {
+		t.Fatal("layer imagery not found")
+	}
+	if got := l.DefaultStyle(); got != "default" {
+		t.Errorf("DefaultStyle() = %q, want \"default\"", got)
+	}
+	if got := len(l.TileTemplates()); got != 2 {
+		t.Errorf("%v tile templates, want 2", got)
+	}
+	if len(l.Dimensions) != 1 || l.Dimensions[0].Identifier != "Time" || l.Dimensions[0].Default != "2018-07-01" {
+		t.Errorf("dimensions %+v", l.Dimensions)
+	}
+	if l.WGS84Bounds.LowerCorner != (Point{-180, -85.051129}) {
+		t.Errorf("lower corner %v", l.WGS84Bounds.LowerCorner)
+	}
+
+	s := c.TileMatrixSet("GoogleMapsCompatible")
+	if s == nil {
+		t.Fatal("tile matrix set GoogleMapsCompatible not found")
+	}
+	if len(s.TileMatrices) != 4 {
+		t.Errorf("%v tile matrices, want 4", len(s.TileMatrices))
+	}
+
+	if c.KVPEndpoint() != "" {
+		t.Errorf("KVPEndpoint() = %q, want none", c.KVPEndpoint())
+	}
+}
+
+func TestURLerRESTful(t *testing.T) {
+	c := parseFixture(t, "restful.xml")
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"", "https://tiles.example.com/wmts/imagery/default/2018-07-01/GoogleMapsCompatible/2/3/1.jpg"},
+		{"image/png", "https://tiles.example.com/wmts/imagery/default/2018-07-01/GoogleMapsCompatible/2/3/1.png"},
+	}
+
+	for _, tt := range tests {
+		u, err := c.URLer("imagery", "", "", tt.format)
+		if err != nil {
+			t.Errorf("URLer(format %q): %v", tt.format, err)
+			continue
+		}
+		if got := u.URL(2, 1, 3).String(); got != tt.want {
+			t.Errorf("URLer(format %q) URL(2, 1, 3) = %q, want %q", tt.format, got, tt.want)
+		}
+		if u.MinZoom() != 0 || u.MaxZoom() != 3 {
+			t.Errorf("zoom range %v to %v, want 0 to 3", u.MinZoom(), u.MaxZoom())
+		}
+	}
+}
+
+func TestURLerTemplates(t *testing.T) {
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{
+			"https://tiles.example.com/wmts?layer=imagery&time={Time}&z={TileMatrix}&row={TileRow}&col={TileCol}",
+			"https://tiles.example.com/wmts?layer=imagery&time=2018-07-01&z=2&row=3&col=1",
+		},
+		{
+			"https://tiles.example.com/pichiwmap/{TileMatrix}1/{TileRow}/{TileCol}.png",
+			"https://tiles.example.com/pichiwmap/21/3/1.png",
+		},
+		{
+			"https://{TileCol}.tiles.example.com/{TileMatrixSet}/{TileMatrix}/{TileRow}.png#{Style}",
+			"https://1.tiles.example.com/GoogleMapsCompatible/2/3.png#default",
+		},
+	}
+
+	for _, tt := range tests {
+		c := parseFixture(t, "restful.xml")
+		c.Layer("imagery").ResourceURLs = []ResourceURL{{Format: "image/png", ResourceType: "tile", Template: tt.template}}
+
+		u, err := c.URLer("imagery", "", "", "")
+		if err != nil {
+			t.Errorf("URLer(%q): %v", tt.template, err)
+			continue
+		}
+		if got := u.URL(2, 1, 3).String(); got != tt.want {
+			t.Errorf("%q URL(2, 1, 3) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestURLerTileSize(t *testing.T) {
+	c := parseFixture(t, "restful.xml")
+
+	u, err := c.URLer("imagery", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.TileSize() != 256 {
+		t.Errorf("GoogleMapsCompatible TileSize() = %v, want 256", u.TileSize())
+	}
+
+	// 512 pixel tiles cover twice the ground, so the matrix at half the scale
+	// denominator of a 256 pixel zoom 0 is zoom 0
+	u, err = c.URLer("imagery", "", "WebMercatorQuad512", "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.TileSize() != 512 {
+		t.Errorf("WebMercatorQuad512 TileSize() = %v, want 512", u.TileSize())
+	}
+	if u.MinZoom() != 0 || u.MaxZoom() != 2 {
+		t.Errorf("zoom range %v to %v, want 0 to 2", u.MinZoom(), u.MaxZoom())
+	}
+	want := "https://tiles.example.com/wmts/imagery/default/2018-07-01/WebMercatorQuad512/z1/0/1.png"
+	if got := u.URL(1, 1, 0).String(); got != want {
+		t.Errorf("URL(1, 1, 0) = %q, want %q", got, want)
+	}
+}
+
+func TestWebMercatorZoomsTileSize(t *testing.T) {
+	matrix := func(id string, scale float64, size int) TileMatrix {
+		return TileMatrix{Identifier: id, ScaleDenominator: scale, TopLeftCorner: webMercatorOrigin, TileWidth: size, TileHeight: size}
+	}
+
+	tests := []struct {
+		matrices []TileMatrix
+		want     string
+	}{
+		{
+			[]TileMatrix{matrix("0", webMercatorScale0, 300)},
+			`tile matrix "0" of "test": unsupported tile size 300x300`,
+		},
+		{
+			[]TileMatrix{{Identifier: "0", ScaleDenominator: webMercatorScale0, TopLeftCorner: webMercatorOrigin, TileWidth: 256, TileHeight: 512}},
+			`tile matrix "0" of "test": unsupported tile size 256x512`,
+		},
+		{
+			[]TileMatrix{matrix("0", webMercatorScale0, 256), matrix("1", webMercatorScale0/4, 512)},
+			`tile matrix "1" of "test": tile size 512 differs from 256`,
+		},
+	}
+
+	for _, tt := range tests {
+		s := &TileMatrixSet{Identifier: "test", SupportedCRS: "EPSG:3857", TileMatrices: tt.matrices}
+		_, err := s.WebMercatorZooms()
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("WebMercatorZooms() = %v, want %v", err, tt.want)
+		}
+	}
+}
+
+func TestURLerKVP(t *testing.T) {
+	c := parseFixture(t, "kvp.xml")
+
+	// The first linked matrix set is geographic, so the web mercator one is used
+	u, err := c.URLer("topp:roads", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mapURL := u.URL(2, 1, 3)
+	if mapURL.Scheme != "https" || mapURL.Host != "maps.example.com" || mapURL.Path != "/gwc/service/wmts" {
+		t.Errorf("URL(2, 1, 3) = %v", mapURL)
+	}
+
+	want := map[string]string{
+		"apikey":        "secret",
+		"SERVICE":       "WMTS",
+		"REQUEST":       "GetTile",
+		"VERSION":       "1.0.0",
+		"LAYER":         "topp:roads",
+		"STYLE":         "line",
+		"FORMAT":        "image/png",
+		"TILEMATRIXSET": "EPSG:900913",
+		"TILEMATRIX":    "EPSG:900913:2",
+		"TILEROW":       "3",
+		"TILECOL":       "1",
+	}
+	query := mapURL.Query()
+	for k, v := range want {
+		if got := query.Get(k); got != v {
+			t.Errorf("URL(2, 1, 3) %v = %q, want %q", k, got, v)
+		}
+	}
+	if u.MaxNativeZoom() != 2 {
+		t.Errorf("MaxNativeZoom() = %v, want 2", u.MaxNativeZoom())
+	}
+}
+
+func TestURLerErrors(t *testing.T) {
+	restful := parseFixture(t, "restful.xml")
+	kvp := parseFixture(t, "kvp.xml")
+	badTemplate := parseFixture(t, "restful.xml")
+	badTemplate.Layer("imagery").ResourceURLs = []ResourceURL{
+		{Format: "image/png", ResourceType: "tile", Template: "https://tiles example.com/{TileMatrix}/{TileRow}/{TileCol}.png"},
+	}
+
+	tests := []struct {
+		c                            *Capabilities
+		layer, tileMatrixSet, format string
+		want                         string
+	}{
+		{
+			kvp, "topp:countries", "", "",
+			`layer "topp:countries" has no supported tile matrix sets: tile matrix set "EPSG:4326": unsupported crs "urn:ogc:def:crs:EPSG::4326" (only web mercator is supported)`,
+		},
+		{
+			kvp, "topp:roads", "EPSG:4326", "",
+			`tile matrix set "EPSG:4326": unsupported crs "urn:ogc:def:crs:EPSG::4326" (only web mercator is supported)`,
+		},
+		{
+			kvp, "topp:roads", "GoogleMapsCompatible", "",
+			`layer "topp:roads" is not available in tile matrix set "GoogleMapsCompatible"`,
+		},
+		{
+			kvp, "topp:rivers", "", "",
+			`layer "topp:rivers" not found`,
+		},
+		{
+			restful, "imagery", "", "image/gif",
+			`layer "imagery" has no tile url for format "image/gif"`,
+		},
+		{
+			badTemplate, "imagery", "", "",
+			`layer "imagery" has an invalid tile url: invalid character " " in host name`,
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := tt.c.URLer(tt.layer, "", tt.tileMatrixSet, tt.format)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("URLer(%q, %q, %q) = %v, want %v", tt.layer, tt.tileMatrixSet, tt.format, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package wmts
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pichiw/pichiwmap"
+)
+
+// webMercatorScale0 is the scale denominator of zoom 0 in the GoogleMapsCompatible
+// well known scale set, using the standard 0.28mm rendering pixel
+const webMercatorScale0 = 559082264.0287178
+
+// webMercatorOrigin is the top left corner of zoom 0 in EPSG:3857 meters
+var webMercatorOrigin = Point{-math.Pi * pichiwmap.EarthRadius, math.Pi * pichiwmap.EarthRadius}
+
+var webMercatorCRSs = []string{"3857", "900913", "3785", "102100", "102113"}
+
+// WebMercatorZooms returns a map of tile matrix identifiers to slippy map zoom
+// levels, or an error describing why the matrix set can't be used. The zoom
+// levels are those of tiles TileSize pixels square, so a 512 pixel tile at zoom
+// 0 covers the world.
+func (s *TileMatrixSet) WebMercatorZooms() (map[string]int, error) {
+	crsOK := false
+	for _, crs := range webMercatorCRSs {
+		if strings.HasSuffix(s.SupportedCRS, ":"+crs) {
+			crsOK = true
+			break
+		}
+	}
+	if !crsOK {
+		return nil, fmt.Errorf("tile matrix set %q: unsupported crs %q (only web mercator is supported)", s.Identifier, s.SupportedCRS)
+	}
+
+	if len(s.TileMatrices) == 0 {
+		return nil, fmt.Errorf("tile matrix set %q has no tile matrices", s.Identifier)
+	}
+
+	// All the zoom levels come from one source, so the tiles have to be the
+	// same size
+	size := s.TileSize()
+	zooms := map[string]int{}
+	for _, m := range s.TileMatrices {
+		if m.TileWidth != m.TileHeight || !pichiwmap.ValidTileSize(m.TileWidth) {
+			return nil, fmt.Errorf("tile matrix %q of %q: unsupported tile size %vx%v", m.Identifier, s.Identifier, m.TileWidth, m.TileHeight)
+		}
+		if m.TileWidth != size {
+			return nil, fmt.Errorf("tile matrix %q of %q: tile size %v differs from %v", m.Identifier, s.Identifier, m.TileWidth, size)
+		}
+
+		z := math.Log2(webMercatorScale0/m.ScaleDenominator) - math.Log2(float64(size)/pichiwmap.TileWidth)
+		zoom := math.Round(z)
+		if math.Abs(z-zoom) > 1e-3 || zoom < 0 {
+			return nil, fmt.Errorf("tile matrix %q of %q: scale denominator %v is not a web mercator zoom level", m.Identifier, s.Identifier, m.ScaleDenominator)
+		}
+
+		if math.Abs(m.TopLeftCorner[0]-webMercatorOrigin[0]) > 1 || math.Abs(m.TopLeftCorner[1]-webMercatorOrigin[1]) > 1 {
+			return nil, fmt.Errorf("tile matrix %q of %q: top left corner %v is not the web mercator origin", m.Identifier, s.Identifier, m.TopLeftCorner)
+		}
+
+		zooms[m.Identifier] = int(zoom)
+	}
+
+	return zooms, nil
+}
+
+// TileSize returns the width of the tiles in the first tile matrix, or 0 if
+// there are none
+func (s *TileMatrixSet) TileSize() int {
+	if len(s.TileMatrices) == 0 {
+		return 0
+	}
+	return s.TileMatrices[0].TileWidth
+}
+
+// URLer creates a URLer for the layer. style, tileMatrixSet and format may be
+// empty in which case the layer's default style, the first supported tile matrix
+// set and the first format are used.
+func (c *Capabilities) URLer(layer, style, tileMatrixSet, format string) (*URLer, error) {
+	l := c.Layer(layer)
+	if l == nil {
+		return nil, fmt.Errorf("layer %q not found", layer)
+	}
+
+	if style == "" {
+		style = l.DefaultStyle()
+	}
+
+	matrixSet, zooms, err := c.layerMatrixSet(l, tileMatrixSet)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &URLer{
+		layer:         l.Identifier,
+		style:         style,
+		tileMatrixSet: matrixSet.Identifier,
+		tileSize:      matrixSet.TileSize(),
+		identifiers:   map[int]string{},
+		dimensions:    map[string]string{},
+		minZoom:       math.MaxInt32,
+		maxZoom:       -1,
+	}
+
+	for id, zoom := range zooms {
+		u.identifiers[zoom] = id
+		if zoom < u.minZoom {
+			u.minZoom = zoom
+		}
+		if zoom > u.maxZoom {
+			u.maxZoom = zoom
+		}
+	}
+
+	for _, d := range l.Dimensions {
+		u.dimensions[d.Identifier] = d.Default
+	}
+
+	for _, r := range l.TileTemplates() {
+		if format == "" || r.Format == format {
+			u.format = r.Format
+			if err := u.parseTemplate(r.Template); err != nil {
+				return nil, fmt.Errorf("layer %q has an invalid tile url: %v", layer, err)
+			}
+			return u, nil
+		}
+	}
+
+	endpoint := c.KVPEndpoint()
+	if endpoint == "" {
+		return nil, fmt.Errorf("layer %q has no tile url for format %q", layer, format)
+	}
+
+	if format == "" {
+		if len(l.Formats) == 0 {
+			return nil, fmt.Errorf("layer %q has no formats", layer)
+		}
+		format = l.Formats[0]
+	}
+	u.format = format
+
+	u.endpoint, err = url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+func (c *Capabilities) layerMatrixSet(l *Layer, identifier string) (*TileMatrixSet, map[string]int, error) {
+	if identifier != "" {
+		linked := false
+		for _, id := range l.TileMatrixSets {
+			linked = linked || id == identifier
+		}
+		if !linked {
+			return nil, nil, fmt.Errorf("layer %q is not available in tile matrix set %q", l.Identifier, identifier)
+		}
+
+		s := c.TileMatrixSet(identifier)
+		if s == nil {
+			return nil, nil, fmt.Errorf("tile matrix set %q not found", identifier)
+		}
+		zooms, err := s.WebMercatorZooms()
+		return s, zooms, err
+	}
+
+	var errs []string
+	for _, id := range l.TileMatrixSets {
+		s := c.TileMatrixSet(id)
+		if s == nil {
+			continue
+		}
+		zooms, err := s.WebMercatorZooms()
+		if err == nil {
+			return s, zooms, nil
+		}
+		errs = append(errs, err.Error())
+	}
+
+	return nil, nil, fmt.Errorf("layer %q has no supported tile matrix sets: %v", l.Identifier, strings.Join(errs, "; "))
+}
+
+// URLer calculates tile URLs for a WMTS layer, using the RESTful template if the
+// server has one and KVP GetTile requests otherwise
+type URLer struct {
+	layer         string
+	style         string
+	format        string
+	tileMatrixSet string
+	tileSize      int
+	identifiers   map[int]string
+	dimensions    map[string]string
+	template      *url.URL
+	prefix        string
+	endpoint      *url.URL
+	minZoom       int
+	maxZoom       int
+}
+
+// MinZoom returns the lowest zoom level the tile matrix set has tiles for
+func (u *URLer) MinZoom() int {
+	return u.minZoom
+}
+
+// MaxZoom returns the highest zoom level the tile matrix set has tiles for
+func (u *URLer) MaxZoom() int {
+	return u.maxZoom
+}
+
+// MaxNativeZoom returns the highest zoom level the tile matrix set has tiles
+// for, so the map scales those tiles up when zoomed in further
+func (u *URLer) MaxNativeZoom() int {
+	return u.maxZoom
+}
+
+// TileSize returns the width and height of the tile matrix set's tiles
+func (u *URLer) TileSize() int {
+	return u.tileSize
+}
+
+func (u *URLer) tileMatrix(zoom int) string {
+	if id, ok := u.identifiers[zoom]; ok {
+		return id
+	}
+	return strconv.Itoa(zoom)
+}
+
+// URL calculates a URL from zoom, x, and y
+func (u *URLer) URL(zoom, x, y int) *url.URL {
+	if u.endpoint != nil {
+		query := u.endpoint.Query()
+		query.Set("SERVICE", "WMTS")
+		query.Set("REQUEST", "GetTile")
+		query.Set("VERSION", "1.0.0")
+		query.Set("LAYER", u.layer)
+		query.Set("STYLE", u.style)
+		query.Set("FORMAT", u.format)
+		query.Set("TILEMATRIXSET", u.tileMatrixSet)
+		query.Set("TILEMATRIX", u.tileMatrix(zoom))
+		query.Set("TILEROW", strconv.Itoa(y))
+		query.Set("TILECOL", strconv.Itoa(x))
+		for k, v := range u.dimensions {
+			query.Set(k, v)
+		}
+
+		mapURL := *u.endpoint
+		mapURL.RawQuery = query.Encode()
+		return &mapURL
+	}
+
+	r := strings.NewReplacer(
+		u.prefix+"0", u.tileMatrix(zoom),
+		u.prefix+"1", strconv.Itoa(y),
+		u.prefix+"2", strconv.Itoa(x),
+	)
+	mapURL := *u.template
+	mapURL.Opaque = r.Replace(mapURL.Opaque)
+	mapURL.Host = r.Replace(mapURL.Host)
+	mapURL.Path = r.Replace(mapURL.Path)
+	mapURL.RawPath = r.Replace(mapURL.RawPath)
+	mapURL.RawQuery = r.Replace(mapURL.RawQuery)
+	mapURL.Fragment = r.Replace(mapURL.Fragment)
+	return &mapURL
+}
+
+// parseTemplate fills in the parts of a RESTful template that are the same for
+// every tile and parses it, with tokens standing in for {TileMatrix}, {TileRow}
+// and {TileCol} so URL only has to replace them. The tokens are a prefix that
+// isn't in the template and a digit, which url.Parse leaves alone wherever they
+// are in a URL.
+func (u *URLer) parseTemplate(template string) error {
+	u.prefix = "pichiwmap"
+	for strings.Contains(template, u.prefix) {
+		u.prefix += "0"
+	}
+
+	replacements := []string{
+		"{Style}", u.style,
+		"{TileMatrixSet}", u.tileMatrixSet,
+		"{TileMatrix}", u.prefix + "0",
+		"{TileRow}", u.prefix + "1",
+		"{TileCol}", u.prefix + "2",
+	}
+	for k, v := range u.dimensions {
+		replacements = append(replacements, "{"+k+"}", v)
+	}
+
+	t, err := url.Parse(strings.NewReplacer(replacements...).Replace(template))
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return err
+	}
+	u.template = t
+	return nil
+}